@@ -28,6 +28,9 @@ type Stmt interface {
 
 type Brackets struct {
 	Args []Expr `json:"args"`
+	// How deep the assignment called may call itself, `depth=3`, 0 leaves it
+	// to the assignment
+	Depth int `json:"depth,omitempty"`
 }
 
 func (o *Brackets) WalkForLines(e *EngineData, lc *LineContainer) error {
@@ -49,11 +52,11 @@ func (o *Brackets) GetSizeInt() int {
 
 func (o *Brackets) GetSizeText(e *EngineData) string {
 	if len(o.Args) == 1 {
-		return o.Args[0].Text(e)
+		return e.argText(o.Args[0])
 	}
 	var s []string
 	for _, arg := range o.Args {
-		s = append(s, arg.Text(e))
+		s = append(s, e.argText(arg))
 	}
 	return strings.Join(s, " ")
 }
@@ -61,7 +64,7 @@ func (o *Brackets) GetSizeText(e *EngineData) string {
 func (o *Brackets) TextSlice(e *EngineData) []string {
 	s := make([]string, 0)
 	for _, arg := range o.Args {
-		s = append(s, arg.Text(e))
+		s = append(s, e.argText(arg))
 	}
 	return s
 }
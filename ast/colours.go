@@ -0,0 +1,269 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ------------------ Yarns ------------------
+
+// Colours a yarn can be named for without giving its hex
+var namedColours = map[string]string{
+	"black":     "#000000",
+	"white":     "#ffffff",
+	"cream":     "#fffdd0",
+	"ivory":     "#fffff0",
+	"natural":   "#e8dcc4",
+	"oatmeal":   "#d8c8a8",
+	"grey":      "#808080",
+	"gray":      "#808080",
+	"charcoal":  "#36454f",
+	"silver":    "#c0c0c0",
+	"red":       "#ff0000",
+	"scarlet":   "#ff2400",
+	"crimson":   "#dc143c",
+	"burgundy":  "#800020",
+	"maroon":    "#800000",
+	"rust":      "#b7410e",
+	"orange":    "#ffa500",
+	"mustard":   "#e1ad01",
+	"gold":      "#ffd700",
+	"yellow":    "#ffff00",
+	"lime":      "#00ff00",
+	"green":     "#008000",
+	"olive":     "#808000",
+	"sage":      "#9caf88",
+	"forest":    "#228b22",
+	"teal":      "#008080",
+	"cyan":      "#00ffff",
+	"turquoise": "#40e0d0",
+	"sky":       "#87ceeb",
+	"blue":      "#0000ff",
+	"denim":     "#1560bd",
+	"navy":      "#000080",
+	"purple":    "#800080",
+	"lilac":     "#c8a2c8",
+	"lavender":  "#e6e6fa",
+	"magenta":   "#ff00ff",
+	"pink":      "#ffc0cb",
+	"rose":      "#ff007f",
+	"brown":     "#8b4513",
+	"tan":       "#d2b48c",
+	"camel":     "#c19a6b",
+}
+
+type yarn struct {
+	name string
+	hex  string
+}
+
+// `#rgb` is short for `#rrggbb`
+func normaliseHex(hex string) string {
+	hex = strings.ToLower(hex)
+	if len(hex) == 4 {
+		return string([]byte{'#', hex[1], hex[1], hex[2], hex[2], hex[3], hex[3]})
+	}
+	return hex
+}
+
+// An alias is a yarn if it's given a hex or aliases a colour by name
+func (s *AliasStmt) colour() (string, bool) {
+	if s.Colour != "" {
+		return normaliseHex(s.Colour), true
+	}
+	hex, ok := namedColours[strings.ToLower(s.Rhs.Name)]
+	return hex, ok
+}
+
+// Hex of the yarn the alias names, if it names one
+func (s *AliasStmt) Hex() (string, bool) {
+	return s.colour()
+}
+
+func (s *Scope) lookupColour(name string) (string, bool) {
+	for ; s != nil; s = s.parent {
+		if hex, ok := s.colours[name]; ok {
+			return hex, true
+		}
+		if _, ok := s.aliases[name]; ok {
+			return "", false
+		}
+		if _, ok := s.assigns[name]; ok {
+			return "", false
+		}
+		if _, ok := s.numbers[name]; ok {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// A yarn given as an argument, `k(3, CC1)`
+func (e *EngineData) yarnArg(arg Expr) (yarn, bool) {
+	var id IdentExpr
+	switch arg := e.substituteParam(arg).(type) {
+	case *IdentExpr:
+		id = *arg
+	case *SizeExpr:
+		if arg.Unit != NOUNIT || arg.Ni >= 0 || arg.Before {
+			return yarn{}, false
+		}
+		id = arg.Id
+	default:
+		return yarn{}, false
+	}
+	hex, ok := e.scope.lookupColour(id.Name)
+	if !ok && !e.isParam(arg) {
+		e.warnNotYarn(id)
+	}
+	return yarn{name: id.Name, hex: hex}, ok && id.Name != ""
+}
+
+func (e *EngineData) isParam(arg Expr) bool {
+	switch arg := arg.(type) {
+	case *IdentExpr:
+		return e.checkParams(*arg) != nil
+	case *SizeExpr:
+		return arg.Id.Name != "" && e.checkParams(arg.Id) != nil
+	}
+	return false
+}
+
+// An alias of a stitch given to a stitch can only be meant as a yarn, but a
+// colour that isn't one of `namedColours` makes it an alias of a stitch; said
+// once each, markers and assignments are given to stitches as themselves
+func (e *EngineData) warnNotYarn(id IdentExpr) {
+	alias, ok := e.scope.lookupAlias(id.Name)
+	if !ok || e.notYarns[alias] || e.isMarker(id) || e.checkAssigns(&id) != nil {
+		return
+	}
+	if e.notYarns == nil {
+		e.notYarns = make(map[*AliasStmt]bool)
+	}
+	e.notYarns[alias] = true
+	e.yarnErrs = append(e.yarnErrs, fmt.Errorf(
+		"%s: %s is given as a yarn but %s isn't a colour that's known, give it a hex to make it one, e.g. %s := %s #rrggbb",
+		alias.Pos().Str(), id.Name, alias.Rhs.Name, id.Name, alias.Rhs.Name))
+}
+
+// Aliases of stitches given to stitches as though they were yarns, found in
+// the walk for lines
+func (e *EngineData) CheckYarns() []error {
+	return e.yarnErrs
+}
+
+// The yarn a stitch is worked in, that given to it, or to the braces it's in,
+// or failing those the first yarn declared
+func (e *EngineData) yarnFor(args Brackets) (yarn, bool) {
+	for _, arg := range args.Args {
+		if y, ok := e.yarnArg(arg); ok {
+			return y, true
+		}
+	}
+	if len(e.yarns) > 0 {
+		return e.yarns[len(e.yarns)-1], true
+	}
+	return e.mainYarn, e.mainYarn.name != ""
+}
+
+// Yarns are shown as `in CC1` rather than by their colour
+func (e *EngineData) argText(arg Expr) string {
+	if y, ok := e.yarnArg(arg); ok {
+		return fmt.Sprintf("in %s", y.name)
+	}
+	return arg.Text(e)
+}
+
+// ------------------ Floats ------------------
+
+// Every yarn worked in the row
+func (c *countElem) yarns() []string {
+	if c.children == nil {
+		if c.yarn == "" {
+			return nil
+		}
+		return []string{c.yarn}
+	}
+	yarns := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, child := range c.children {
+		for _, y := range child.yarns() {
+			if !seen[y] {
+				seen[y] = true
+				yarns = append(yarns, y)
+			}
+		}
+	}
+	return yarns
+}
+
+// Each yarn not worked is carried behind the stitches that are, from the
+// first stitch worked in it, it's too long once it's carried further than
+// `maxFloat`
+func (w *worker) strand(c *countElem) {
+	if w.floats == nil {
+		return
+	}
+	for y, float := range w.floats {
+		if y != c.yarn {
+			if float >= 0 {
+				w.floats[y] += c.def.Produces
+			}
+			continue
+		}
+		if float > w.maxFloat {
+			w.floatErrs = append(w.floatErrs, fmt.Errorf("%s: %s is carried behind %d stitches, more than %d",
+				c.at.Str(), y, float, w.maxFloat))
+		}
+		w.floats[y] = 0
+	}
+}
+
+// Stranded rows, those in more than one yarn, with a yarn carried further than
+// `maxFloat` stitches, needs the stitches to have been counted; errors are
+// unique and in order
+func (e *Engine) CheckFloats(maxFloat int) []error {
+	errs := make([]error, 0)
+	seen := make(map[string]bool)
+	var before CurrentState
+	e.walk(func(idx int, s *CurrentState, _ error) {
+		if idx > 0 {
+			for _, err := range floatErrs(&before, s, maxFloat) {
+				if !seen[err.Error()] {
+					seen[err.Error()] = true
+					errs = append(errs, err)
+				}
+			}
+		}
+		before = *s
+	})
+	return errs
+}
+
+// Yarns carried too far working `s` on what `before` left on the needle
+func floatErrs(before, s *CurrentState, maxFloat int) []error {
+	count := s.Lc.stitches()
+	yarns := count.yarns()
+	if len(yarns) < 2 || before.Stitches < 0 {
+		return nil
+	}
+	w := &worker{
+		live:     before.Stitches,
+		ahead:    append(make([]Marker, 0, len(before.Markers)), markersMet(before, s)...),
+		floats:   make(map[string]int, len(yarns)),
+		maxFloat: maxFloat,
+	}
+	// Not carried until it's been worked
+	for _, y := range yarns {
+		w.floats[y] = -1
+	}
+	w.work(count, 0)
+	// Those not worked again are carried to the end of the row
+	for _, y := range yarns {
+		if float := w.floats[y]; float > maxFloat {
+			w.floatErrs = append(w.floatErrs, fmt.Errorf("%s: %s is carried behind %d stitches to the end of the row, more than %d",
+				count.at.Str(), y, float, maxFloat))
+		}
+	}
+	return w.floatErrs
+}
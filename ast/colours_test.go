@@ -0,0 +1,48 @@
+package ast_test
+
+import (
+	"strings"
+	"testing"
+)
+
+func yarnWarnings(t *testing.T, text string) []error {
+	return compile(t, "test.knit", text).EngineData().CheckYarns()
+}
+
+func TestCheckYarns(t *testing.T) {
+	errs := yarnWarnings(t, "MC := navy\nCC := heather\nco(10)\nk(5, CC) k(5, MC)\np(5, CC) p(5)\n")
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "CC is given as a yarn but heather") {
+		t.Errorf("Want one warning for CC, got %v", errs)
+	}
+
+	// Given to stitches as themselves rather than as yarns
+	quiet := map[string]string{
+		"marker":     "m := marker\nco(10)\nk(5) place-marker(m) k(5)\nk(m) slip(m) k(*)\n",
+		"assignment": "rib(n) = { k(n) p(n) }(*)\nr := rib\nco(10)\nrib(1)\nk(5, r) k(5)\n",
+		"parameter":  "K := knit\nedge(y) = { k(2, y) }\nco(4)\nedge(K) k(2)\n",
+	}
+	for what, text := range quiet {
+		if errs := yarnWarnings(t, text); len(errs) > 0 {
+			t.Errorf("A %s is warned about as a yarn: %v", what, errs)
+		}
+	}
+}
+
+// Measured where the yarn is picked up again, or at the end of the row
+func TestCheckFloats(t *testing.T) {
+	cases := map[string]string{
+		"k(2, MC) k(6, CC) k(2, MC)\n": "test.knit:4:19: MC is carried behind 6 stitches, more than 5",
+		"k(2, MC) k(8, CC)\n":          "test.knit:4:1: MC is carried behind 8 stitches to the end of the row, more than 5",
+		"k(2, MC) k(4, CC) k(4, MC)\n": "",
+	}
+	for row, want := range cases {
+		text := "MC := navy\nCC := cream\nco(10)\n" + row
+		errs := compile(t, "test.knit", text).CheckFloats(5)
+		switch {
+		case want == "" && len(errs) > 0:
+			t.Errorf("%q gave %v", row, errs)
+		case want != "" && (len(errs) != 1 || errs[0].Error() != want):
+			t.Errorf("%q gave %v, want %q", row, errs, want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/bodneyc/knit-and-go/lexer"
+)
+
+// ------------------ ConstructionStmt ------------------
+
+type Construction string
+
+const (
+	NO_CONSTRUCTION Construction = ""
+	FLAT            Construction = "flat"
+	ROUND           Construction = "round"
+)
+
+// `flat` or `round` on its own line, for the rest of the block or assignment
+// it's in
+type ConstructionStmt struct {
+	At   Position         `json:"at"`
+	In   Construction     `json:"in"`
+	Desc CommentGroupExpr `json:"desc"`
+}
+
+func NewConstructionStmt(desc CommentGroupExpr, at Position, in Construction) *ConstructionStmt {
+	return &ConstructionStmt{
+		At:   at,
+		In:   in,
+		Desc: desc,
+	}
+}
+
+func (s *ConstructionStmt) stmtNode()     {}
+func (s *ConstructionStmt) Pos() Position { return s.At }
+
+func (s *ConstructionStmt) WalkForLines(e *EngineData) error {
+	e.scope.construction = s.In
+	return nil
+}
+
+func (s *ConstructionStmt) WalkForLocals(e *EngineData) {}
+
+func (s *Scope) lookupConstruction() Construction {
+	for ; s != nil; s = s.parent {
+		if s.construction != NO_CONSTRUCTION {
+			return s.construction
+		}
+	}
+	return NO_CONSTRUCTION
+}
+
+// That given in the assignment being walked, or failing that wherever it was
+// called from, rather than where it was declared
+func (e *EngineData) construction() Construction {
+	s := e.scope
+	for i := len(e.callers) - 1; i >= 0; i-- {
+		for ; s != nil && s != e.callers[i].closure; s = s.parent {
+			if s.construction != NO_CONSTRUCTION {
+				return s.construction
+			}
+		}
+		s = e.callers[i].caller
+	}
+	return s.lookupConstruction()
+}
+
+// An assignment is walked in the scope it was declared in, `closure`, having
+// been called at `at`, with the depth the call gave, if any
+type call struct {
+	caller  *Scope
+	closure *Scope
+	assign  *AssignStmt
+	at      Position
+	depth   int
+}
+
+// ------------------ Sides ------------------
+
+type Side string
+
+const (
+	NO_SIDE    Side = ""
+	RIGHT_SIDE Side = "RS"
+	WRONG_SIDE Side = "WS"
+)
+
+func (s Side) other() Side {
+	switch s {
+	case RIGHT_SIDE:
+		return WRONG_SIDE
+	case WRONG_SIDE:
+		return RIGHT_SIDE
+	}
+	return NO_SIDE
+}
+
+// A row that only casts on isn't worked from either side, the row after it is
+// the first right side row
+func (c *countElem) castsOn() bool {
+	if c == nil {
+		return false
+	}
+	l, ok := c.linear()
+	return ok && l.open == nil && l.cons == 0 && l.prod > 0
+}
+
+// A row of only setup stitches, casting on, placing markers or getting the
+// needles out, is a setup instruction rather than a row worked; yarn overs on
+// their own still make a row
+func (c *countElem) setsUp() bool {
+	if c == nil {
+		return false
+	}
+	if c.children == nil {
+		return c.known && c.def.Setup
+	}
+	for _, child := range c.children {
+		if !child.setsUp() {
+			return false
+		}
+	}
+	return len(c.children) > 0
+}
+
+// The marker a row meets first, working flat, is the last one placed by the
+// row before; positions are counted from the start of the row as it's worked
+func mirrorMarkers(markers []Marker, live int) []Marker {
+	mirrored := make([]Marker, len(markers))
+	for i, m := range markers {
+		mirrored[len(markers)-1-i] = Marker{Name: m.Name, At: live - m.At}
+	}
+	return mirrored
+}
+
+// The markers on the needle working `s`, those left by the state before
+func markersMet(before, s *CurrentState) []Marker {
+	if s.Side != NO_SIDE && !s.Round && before.Stitches >= 0 && len(s.Lc.Phrases) > 0 {
+		return mirrorMarkers(before.Markers, before.Stitches)
+	}
+	return before.Markers
+}
+
+func (e *Engine) MarkersMetText(idx int) string {
+	if idx < 1 {
+		return ""
+	}
+	before, s := e.StateAt(idx-1), e.StateAt(idx)
+	return markersText(markersMet(&before, &s))
+}
+
+// Chart rows worked on the other side from how they're charted, errors are
+// unique and in order
+func (e *Engine) CheckSides() []error {
+	errs := make([]error, 0)
+	seen := make(map[string]bool)
+	e.walk(func(idx int, s *CurrentState, _ error) {
+		charted := s.Lc.side
+		if charted == NO_SIDE || s.Side == NO_SIDE || charted == s.Side {
+			return
+		}
+		err := fmt.Errorf("%s: %s is charted as a %s row but is worked on the %s",
+			s.Lc.at.Str(), strings.TrimSpace(s.Desc.Row), charted, s.Side)
+		if !seen[err.Error()] {
+			seen[err.Error()] = true
+			errs = append(errs, err)
+		}
+	})
+	return errs
+}
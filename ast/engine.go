@@ -5,58 +5,61 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
 	"strconv"
 	"strings"
 
+	. "github.com/bodneyc/knit-and-go/lexer"
 	log "github.com/sirupsen/logrus"
 )
 
-var (
-	START_OF_GROUP_LC = LineContainer{Row: []string{"START: GROUP"}}
-	END_OF_GROUP_LC   = LineContainer{Row: []string{"END: GROUP"}}
-
-	START_OF_ROW_LC = LineContainer{Row: []string{"START: ROW"}}
-	END_OF_ROW_LC   = LineContainer{Row: []string{"END: ROW"}}
-
-	START_OF_BLOCK_LC = LineContainer{Row: []string{"START: BLOCK"}}
-	END_OF_BLOCK_LC   = LineContainer{Row: []string{"END: BLOCK"}}
-)
-
 // ------------------ LineContainer ------------------
 
 type LineContainer struct {
 	Desc []string
 	Args []string
-	Row  []string
+	// Each fragment of the row as it's worked, and how many times they're
+	// worked along the row, `k p (*)`
+	Phrases []Phrase `json:",omitempty"`
+	Across  Count
+	approx  int
+	until   bool
+	// Whether the row is worked flat or in the round and, for a chart row,
+	// the side it's charted on
+	at           Position
+	construction Construction
+	side         Side
 }
 
 func MakeLineContainer() LineContainer {
 	return LineContainer{
 		Desc: make([]string, 0),
 		Args: make([]string, 0),
-		Row:  make([]string, 0),
 	}
 }
 
-func (o *LineContainer) rowIsEqual(lc LineContainer) bool {
-	if len(o.Row) != len(lc.Row) {
-		return false
-	}
-	for i, s := range o.Row {
-		if s != lc.Row[i] {
-			return false
-		}
-	}
-	return true
+func (o *LineContainer) phraseIs(idx int, kind PhraseKind) bool {
+	return idx >= 0 && idx < len(o.Phrases) && o.Phrases[idx].Kind == kind
+}
+
+// A brace around stitches repeated within the row rather than a stitch
+func (o *LineContainer) IsBrace(idx int) bool {
+	return o.phraseIs(idx, OPEN_PK) || o.phraseIs(idx, CLOSE_PK)
+}
+
+// No comma after an opening brace, or before a closing one
+func (o *LineContainer) Braced(idx int) bool {
+	return o.phraseIs(idx, OPEN_PK) || o.phraseIs(idx+1, CLOSE_PK)
 }
 
 func (o *LineContainer) prettyRow() string {
 	var s []string
-	for idx, fragment := range o.Row {
-		if fragment == "{" || (len(o.Row) > idx+1 && o.Row[idx+1][0] == '}') {
+	for idx, p := range o.Phrases {
+		fragment := p.Text
+		if o.Braced(idx) {
 			s = append(s, fragment)
 		} else {
-			if idx == len(o.Row)-1 {
+			if idx == len(o.Phrases)-1 {
 				s = append(s, fmt.Sprintf("%s", fragment))
 			} else {
 				s = append(s, fmt.Sprintf("%s,", fragment))
@@ -66,86 +69,192 @@ func (o *LineContainer) prettyRow() string {
 	return strings.Join(s, " ")
 }
 
-func (o *LineContainer) isEmpty() bool {
-	return len(o.Desc) == 0 && len(o.Args) == 0 && len(o.Row) == 0
-}
-
 // ------------------ EngineData ------------------
 
 type EngineData struct {
-	Lines       []LineContainer
-	aliases     map[string]IdentExpr
-	assigns     map[string]*Expr
-	nestedRow   bool
-	nestedLevel int
+	Instrs   []Instr
+	scope    *Scope
+	closures map[*AssignStmt]*Scope
+	callers  []call
+	owners   []Node
+	decls    map[Node]map[string]Position
+	params   []map[string]Expr
+	sizes    []string
+	sizeIdx  int
+	size     string
+	// Given to the braces being walked, and the first declared
+	yarns    []yarn
+	mainYarn yarn
+	notYarns map[*AliasStmt]bool
+	yarnErrs []error
+	// How deep an assignment may call itself, none if 0
+	maxDepth int
 }
 
 func NewEngineData() *EngineData {
 	return &EngineData{
-		aliases:   make(map[string]IdentExpr),
-		assigns:   make(map[string]*Expr),
-		Lines:     make([]LineContainer, 0),
-		nestedRow: false,
-		// Nested level may be a little redundant
-		nestedLevel: 1,
-	}
-}
-
-func (e *EngineData) PrintLines() {
-	for _, line := range e.Lines {
-		if len(line.Desc) > 0 {
-			fmt.Println(strings.Join(line.Desc, "\n"))
-		}
-		if len(line.Row) > 0 {
-			fmt.Printf("row: %s\n", line.prettyRow())
-		}
-		if len(line.Args) > 0 {
-			fmt.Printf(" args: %s\n", strings.Join(line.Args, ", "))
-		}
+		scope:    nil,
+		closures: make(map[*AssignStmt]*Scope),
+		owners:   make([]Node, 0),
+		decls:    make(map[Node]map[string]Position),
+		params:   make([]map[string]Expr, 0),
+		Instrs:   make([]Instr, 0),
 	}
 }
 
+// What a name ends up as once all its aliases are followed
 func (e *EngineData) checkAliases(o IdentExpr) IdentExpr {
-	if alias, ok := e.aliases[o.Name]; ok {
-		return alias
+	if chain := e.aliasChain(o); len(chain) > 0 {
+		return chain[len(chain)-1].Rhs
 	}
 	return o
 }
 
-func (e *EngineData) checkAssigns(o *IdentExpr) *Expr {
-	if assign, ok := e.assigns[o.Name]; ok {
+// An alias can name an assignment, `stock := stockinette`
+func (e *EngineData) checkAssigns(o *IdentExpr) *AssignStmt {
+	if assign, ok := e.scope.lookupAssign(e.checkAliases(*o).Name); ok {
 		return assign
 	}
 	return nil
 }
 
+// Only the parameters of the innermost call are visible
+func (e *EngineData) checkParams(o IdentExpr) Expr {
+	if len(e.params) == 0 {
+		return nil
+	}
+	if param, ok := e.params[len(e.params)-1][o.Name]; ok {
+		return param
+	}
+	return nil
+}
+
+func (e *EngineData) substituteParam(arg Expr) Expr {
+	switch arg.(type) {
+	case *VariantExpr:
+		return e.substituteParam(arg.(*VariantExpr).selected(e))
+	case *ArithExpr:
+		if n, err := e.evalNumber(arg); err == nil {
+			return makeNumber(arg.Pos(), n)
+		}
+	case *IdentExpr:
+		if param := e.checkParams(*arg.(*IdentExpr)); param != nil {
+			return param
+		}
+		// Numbers are resolved here as a call may not see them from its rhs
+		if n, ok := e.lookupNumber(arg.(*IdentExpr).Name); ok {
+			return makeNumber(arg.Pos(), n)
+		}
+	case *SizeExpr:
+		size := arg.(*SizeExpr)
+		if size.Id.Name == "" {
+			break
+		}
+		param := e.checkParams(size.Id)
+		if param == nil {
+			n, ok := e.lookupNumber(size.Id.Name)
+			if !ok || size.Ni != -1 || size.Nf != -1 {
+				break
+			}
+			param = makeNumber(size.Pos(), n)
+		}
+		sub := *size
+		switch param.(type) {
+		case *IdentExpr:
+			sub.Id = *param.(*IdentExpr)
+		case *SizeExpr:
+			paramSize := param.(*SizeExpr)
+			sub.Ni, sub.Nf, sub.Id = paramSize.Ni, paramSize.Nf, paramSize.Id
+			if sub.Unit == NOUNIT {
+				sub.Unit = paramSize.Unit
+			}
+		}
+		return &sub
+	}
+	return arg
+}
+
 // ------------------ CurrentState ------------------
 
 type Counters struct {
 	Stitch, Row, StitchPhrase int
 }
 
-func (c *Counters) reset() {
-	c.Stitch = 0
-	c.Row = 0
-	c.StitchPhrase = 0
-}
-
 type Descs struct {
 	Row   string
 	Group string
 	Block string
 }
 
+// One of the repeats a state is in, `Ctr` of `Max` times; a group is named for
+// the assignment called, otherwise it's a "group" or a "row". `Row` is the
+// row worked in this pass of it, of `PassRows`
+type Level struct {
+	Name     string
+	Called   bool
+	Rows     bool
+	Ctr      int
+	Max      int
+	Approx   bool
+	Until    string
+	Row      int
+	PassRows int
+}
+
+// As the TUI shows the counter, with any estimate, `lace 3/~8, until 19"`
+func (l Level) String() string {
+	approx := ""
+	if l.Approx {
+		approx = "~"
+	}
+	switch {
+	case l.Until != "" && l.Max != 0:
+		return fmt.Sprintf("%s %d/%s%d, %s", l.Name, l.Ctr, approx, l.Max, UntilPhrase(l.Until))
+	case l.Until != "":
+		return fmt.Sprintf("%s %d/%s", l.Name, l.Ctr, UntilPhrase(l.Until))
+	case l.Max > 1:
+		return fmt.Sprintf("%s %d/%s%d", l.Name, l.Ctr, approx, l.Max)
+	}
+	return l.Name
+}
+
 type CurrentState struct {
-	Lc       LineContainer
-	Desc     Descs
-	Ctr      Counters
-	HistRow  string
+	Lc      LineContainer
+	Desc    Descs
+	Ctr     Counters
+	HistRow string
+	// Those of the innermost group and row repeat, every repeat the state is
+	// in is in `Repeats`, outermost first
 	GroupCtr int
 	RowCtr   int
 	GroupMax int
 	RowMax   int
+	Repeats  []Level
+	// Numbered from the first row of the pattern, setup instructions, like a
+	// cast-on, aren't rows and have none
+	Row      int
+	Setup    bool
+	Stitches int
+	// On the needle after the row, like the stitches
+	Markers []Marker
+	// The max was worked out from a measurement and the gauge
+	GroupApprox bool
+	RowApprox   bool
+	// Worked until a measurement or condition is met rather than a set number
+	// of times, e.g. `19"`
+	GroupUntil string
+	RowUntil   string
+	// Only on the last state of an open-ended repeat, the number of states
+	// repeated, whether the repeat is of rows, and how many times it's been
+	// worked; done once the knitter has confirmed the condition is met
+	LoopLen  int
+	LoopRows bool
+	Worked   int
+	Done     bool
+	// The side the row is worked from, none unless the pattern says whether
+	// it's knitted flat or in the round
+	Side  Side
+	Round bool
 }
 
 func MakeCurrentState() CurrentState {
@@ -158,10 +267,38 @@ func MakeCurrentState() CurrentState {
 		RowCtr:   1,
 		GroupMax: 0,
 		RowMax:   0,
+		Stitches: -1,
+		Markers:  nil,
+
+		GroupApprox: false,
+		RowApprox:   false,
+		GroupUntil:  "",
+		RowUntil:    "",
+		LoopLen:     0,
+		LoopRows:    false,
+		Worked:      0,
+		Done:        false,
+		Side:        NO_SIDE,
+		Round:       false,
 	}
 }
 
 func (o CurrentState) String() string {
+	extra := ""
+	if len(o.Markers) > 0 {
+		extra = fmt.Sprintf("\nMarkers:\n%s", o.MarkersText())
+	}
+	if o.Side != NO_SIDE {
+		extra += fmt.Sprintf("\nSide:\n%s", o.SideText())
+	}
+	if crumbs := o.Breadcrumb(); crumbs != "" {
+		extra += fmt.Sprintf("\nRepeats:\n%s", crumbs)
+	}
+	extra += fmt.Sprintf("\nPattern row:\n%s", o.RowText())
+	if o.LoopLen > 0 {
+		extra += fmt.Sprintf("\nRepeat of %d state(s) %s, worked %d time(s)",
+			o.LoopLen, UntilPhrase(o.until()), o.Worked)
+	}
 	return fmt.Sprintf(`----------------------
 Block desc.Title = ""
 %s
@@ -172,7 +309,9 @@ Row desc:
 Row:
 %s
 Args:
-%s`,
+%s
+Stitches:
+%s%s`,
 		o.Desc.Block,
 		o.GroupCtr,
 		o.GroupMax,
@@ -180,39 +319,492 @@ Args:
 		o.Desc.Row,
 		o.Lc.prettyRow(),
 		strings.Join(o.Lc.Args, ", "),
+		o.StitchesText(),
+		extra,
 	)
 }
 
+// A measurement, `19"`, reads as `until 19"`, a condition may say so itself
+func UntilPhrase(until string) string {
+	if strings.HasPrefix(until, "until ") || strings.HasPrefix(until, "to ") {
+		return until
+	}
+	return "until " + until
+}
+
+// The repeats the state is in and the pass of each, then the row in the pass
+// of the innermost group, `main > lace 3/8 > row 7/20`; `row 2/4` before the
+// row is a row worked 4 times. Those worked once that aren't a call are left
+// out
+func (o CurrentState) Breadcrumb() string {
+	crumbs := make([]string, 0, len(o.Repeats)+1)
+	row := ""
+	for _, l := range o.Repeats {
+		if l.Called || l.Max > 1 || l.Until != "" {
+			crumbs = append(crumbs, l.String())
+			if !l.Rows && !o.Setup {
+				row = fmt.Sprintf("row %d/%d", l.Row, l.PassRows)
+			}
+		}
+	}
+	if row != "" {
+		crumbs = append(crumbs, row)
+	}
+	return strings.Join(crumbs, " > ")
+}
+
+// Rows as a printed pattern numbers them, `row 57, row 12 of sleeve`, from the
+// start of the pattern and of each assignment called
+func (o CurrentState) RowText() string {
+	if o.Setup {
+		return "setup"
+	}
+	rows := []string{fmt.Sprintf("row %d", o.Row)}
+	for _, l := range o.Repeats {
+		if l.Called {
+			rows = append(rows, fmt.Sprintf("row %d of %s", l.Row, l.Name))
+		}
+	}
+	return strings.Join(rows, ", ")
+}
+
+// The condition ending the innermost open-ended repeat
+func (o CurrentState) until() string {
+	if o.RowUntil != "" {
+		return o.RowUntil
+	}
+	return o.GroupUntil
+}
+
+func (o CurrentState) MarkersText() string {
+	return markersText(o.Markers)
+}
+
+func markersText(markers []Marker) string {
+	texts := make([]string, len(markers))
+	for i, m := range markers {
+		texts[i] = m.String()
+	}
+	return strings.Join(texts, ", ")
+}
+
+// `RS` or `WS`, with `in the round` if it is
+func (o CurrentState) SideText() string {
+	if o.Round {
+		return fmt.Sprintf("%s, in the round", o.Side)
+	}
+	return string(o.Side)
+}
+
+func (o CurrentState) StitchesText() string {
+	if o.Stitches < 0 {
+		return "unknown"
+	}
+	return strconv.Itoa(o.Stitches)
+}
+
 // ------------------ Engine ------------------
 
+// States are worked out from the program as they're needed, the carry is kept
+// every this many states so none is worked out from further back
+const CHECKPOINT_LEN = 64
+
+// Where the pattern was read from, enough to compile it again
+type PatternSource struct {
+	Infiles        []string
+	ImportPaths    []string `json:",omitempty"`
+	Ast            bool     `json:",omitempty"`
+	Size           string   `json:",omitempty"`
+	RecursionDepth int      `json:",omitempty"`
+}
+
+// How far an open-ended repeat has been worked, kept by its last state
+type Progress struct {
+	Worked int
+	Done   bool
+}
+
+// The first and last state flipped to the other side by the open-ended repeat
+// ending at a state, as it's been worked an odd number of flat rows again
+type span struct {
+	start, stop int
+}
+
+// Rows worked again in the open-ended repeat ending at a state, they come
+// before every row from its start on
+type shift struct {
+	start, rows int
+}
+
 type Engine struct {
-	States     []CurrentState
 	StateIdx   int
-	engineData *EngineData
 	StatesFile string
+	Source     PatternSource
+	engineData *EngineData
+	program    *repeat
+	steps      []CurrentState
+	counted    bool
+	marks      []carry
+	current    *CurrentState
+	// Only counters the knitter has changed and repeats they've worked
+	counters map[int]Counters
+	loops    map[int]Progress
+	flips    map[int]span
+	shifts   map[int]shift
 }
 
 func MakeEngine(e *EngineData, s string) Engine {
 	return Engine{
-		States:     make([]CurrentState, 0),
 		StateIdx:   0,
-		engineData: e,
 		StatesFile: s,
+		engineData: e,
+		program:    &repeat{times: 1},
+		steps:      make([]CurrentState, 0),
+		counters:   make(map[int]Counters),
+		loops:      make(map[int]Progress),
+		flips:      make(map[int]span),
+		shifts:     make(map[int]shift),
 	}
 }
 
-func MakeEngineFromStatesFile(StatesFile string) (Engine, error) {
-	var engine Engine
-	statesJson, err := ioutil.ReadFile(StatesFile)
-	if err != nil {
-		return engine, err
+func (e *Engine) Len() int {
+	return e.program.len
+}
+
+// The state being worked, changes to its counters are kept
+func (e *Engine) State() *CurrentState {
+	if e.current == nil {
+		s := e.StateAt(e.StateIdx)
+		e.current = &s
 	}
-	err = json.Unmarshal([]byte(statesJson), &engine)
+	return e.current
+}
+
+func (e *Engine) StateAt(idx int) CurrentState {
+	if idx < 0 || idx >= e.Len() {
+		return MakeCurrentState()
+	}
+	if idx == e.StateIdx && e.current != nil {
+		return *e.current
+	}
+	if len(e.marks) <= idx/CHECKPOINT_LEN {
+		e.walk(func(int, *CurrentState, error) {})
+	}
+	k := e.marks[idx/CHECKPOINT_LEN]
+	c := e.locate(idx - idx%CHECKPOINT_LEN)
+	for {
+		s, _ := e.materialise(&c, &k)
+		if c.idx == idx {
+			return s
+		}
+		c.next()
+	}
+}
+
+// Counters left at zero aren't kept
+func (e *Engine) save() {
+	if e.current == nil {
+		return
+	}
+	if e.current.Ctr == (Counters{}) {
+		delete(e.counters, e.StateIdx)
+	} else {
+		e.counters[e.StateIdx] = e.current.Ctr
+	}
+}
+
+// Before moving off the state being worked, or changing what it's worked out as
+func (e *Engine) keep() {
+	e.save()
+	e.current = nil
+}
+
+// The state the cursor is at, worked from the carry of those before
+func (e *Engine) materialise(c *cursor, k *carry) (CurrentState, error) {
+	for _, f := range c.frames {
+		if f.repeat.loop && c.idx == f.base {
+			e.workAgain(f, k)
+		}
+	}
+	s := e.steps[c.step()]
+	err := k.work(&s, e.counted)
+	// The root isn't a repeat
+	s.Repeats = make([]Level, 0, len(c.frames)-1)
+	for _, f := range c.frames[1:] {
+		l := f.repeat.level(f.iter)
+		if f.repeat.loop {
+			end := f.base + f.repeat.len - 1
+			p := e.loops[end]
+			l.Ctr = p.reworked() + 1
+			if c.idx == end {
+				s.LoopLen, s.LoopRows, s.Worked, s.Done = f.repeat.len, f.repeat.rows, p.Worked, p.Done
+			}
+		}
+		if l.Rows {
+			s.RowCtr, s.RowMax, s.RowApprox, s.RowUntil = l.Ctr, l.Max, l.Approx, l.Until
+		} else {
+			s.GroupCtr, s.GroupMax, s.GroupApprox, s.GroupUntil = l.Ctr, l.Max, l.Approx, l.Until
+		}
+		s.Repeats = append(s.Repeats, l)
+	}
+	// Each repeat's rows are counted from the start of its pass, the
+	// pattern's from the start
+	s.Setup = s.Lc.stitches().setsUp()
+	inner := 0
+	for j := len(c.frames) - 1; j >= 0 && !s.Setup; j-- {
+		f := c.frames[j]
+		row := inner + f.repeat.before[f.part] + e.reworkedRows(f, c.idx) + 1
+		inner += f.rowsBefore()
+		if j == 0 {
+			s.Row = row
+		} else {
+			s.Repeats[j-1].Row = row
+			s.Repeats[j-1].PassRows = f.repeat.worked + e.reworkedRows(f, f.base+f.repeat.len-1)
+		}
+	}
+	if s.Side != NO_SIDE && !s.Round && e.flipped(c.idx) {
+		s.Side = s.Side.other()
+	}
+	s.Ctr = e.counters[c.idx]
+	return s, err
+}
+
+// Rows worked again in open-ended repeats in the pass of `f` before `idx`, an
+// open-ended repeat's own passes start again
+func (e *Engine) reworkedRows(f frame, idx int) int {
+	if f.repeat.loop {
+		return 0
+	}
+	rows := 0
+	for _, sh := range e.shifts {
+		if f.base <= sh.start && sh.start <= idx {
+			rows += sh.rows
+		}
+	}
+	return rows
+}
+
+// The state worked as row `row` of the pattern
+func (e *Engine) rowState(row int) (int, bool) {
+	found := -1
+	e.walk(func(idx int, s *CurrentState, _ error) {
+		if found < 0 && !s.Setup && s.Row == row {
+			found = idx
+		}
+	})
+	return found, found >= 0
+}
+
+// Every state in order, the carry is kept as it goes
+func (e *Engine) walk(fn func(idx int, s *CurrentState, err error)) {
+	k := startCarry()
+	for c, ok := e.begin(); ok; ok = c.next() {
+		if c.idx%CHECKPOINT_LEN == 0 && len(e.marks) == c.idx/CHECKPOINT_LEN {
+			e.marks = append(e.marks, k)
+		}
+		s, err := e.materialise(&c, &k)
+		fn(c.idx, &s, err)
+	}
+}
+
+// Passes of an open-ended repeat worked again are on the needle before its
+// first state; sides are left to the flips
+func (e *Engine) workAgain(f frame, k *carry) {
+	side := k.side
+	for pass := e.loops[f.base+f.repeat.len-1].reworked(); pass > 0; pass-- {
+		c := e.locate(f.base)
+		for i := 0; i < f.repeat.len; i++ {
+			s := e.steps[c.step()]
+			k.work(&s, e.counted)
+			c.next()
+		}
+	}
+	k.side = side
+}
+
+// Passes worked again, the one the condition was met on isn't
+func (p Progress) reworked() int {
+	if p.Done {
+		return p.Worked - 1
+	}
+	return p.Worked
+}
+
+func (e *Engine) flipped(idx int) bool {
+	flipped := false
+	for _, sp := range e.flips {
+		if sp.start <= idx && idx < sp.stop {
+			flipped = !flipped
+		}
+	}
+	return flipped
+}
+
+// Working rows again moves the number of every row after, and working an odd
+// number of flat rows again swaps the side of every row from the start of the
+// repeat up to the next cast-on or rows in the round
+func (e *Engine) setLoop(end int, p Progress) {
+	e.keep()
+	delete(e.flips, end)
+	delete(e.shifts, end)
+	if p == (Progress{}) {
+		delete(e.loops, end)
+		return
+	}
+	e.loops[end] = p
+	f, ok := e.loopFrame(end)
+	if !ok {
+		return
+	}
+	// What's carried from the start of the repeat on has changed
+	if keep := f.base/CHECKPOINT_LEN + 1; len(e.marks) > keep {
+		e.marks = e.marks[:keep]
+	}
+	if rows := f.repeat.worked * p.reworked(); rows > 0 {
+		e.shifts[end] = shift{start: f.base, rows: rows}
+	}
+	if f.repeat.flat*p.reworked()%2 == 0 {
+		return
+	}
+	c := e.locate(end)
+	for ok := c.next(); ok; ok = c.next() {
+		if lc := e.steps[c.step()].Lc; lc.stitches().castsOn() || lc.construction == ROUND {
+			break
+		}
+	}
+	e.flips[end] = span{start: f.base, stop: c.idx}
+}
+
+// ------------------ States file ------------------
+
+// All that's saved of a run, the pattern is compiled again from its source so
+// only the knitter's place, their counters and how far open-ended repeats
+// have been worked are kept
+type SavedStates struct {
+	Source   PatternSource
+	States   int
+	StateIdx int
+	// The row of the pattern the state is, so the place is kept if the pattern
+	// changes; none on a setup instruction
+	Row      int              `json:",omitempty"`
+	Counters map[int]Counters `json:",omitempty"`
+	Loops    map[int]Progress `json:",omitempty"`
+}
+
+func ReadStatesFile(statesFile string) (SavedStates, error) {
+	var saved SavedStates
+	statesJson, err := ioutil.ReadFile(statesFile)
 	if err != nil {
-		return engine, err
+		return saved, err
+	}
+	// Older states files hold every state rather than where they came from,
+	// so there's no pattern to pick up
+	var old struct{ States []json.RawMessage }
+	if json.Unmarshal(statesJson, &old) == nil && old.States != nil {
+		return saved, fmt.Errorf("%s predates states files saving the pattern they're from, run the .knit again with --states %s to make a new one",
+			statesFile, statesFile)
 	}
-	engine.StatesFile = StatesFile
-	return engine, err
+	err = json.Unmarshal([]byte(statesJson), &saved)
+	saved.Source = saved.Source.moved(filepath.Dir(statesFile), func(dir, path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	})
+	return saved, err
+}
+
+// Paths are saved relative to the states file where they can be, so a pattern
+// and its states can be moved together
+func (s PatternSource) moved(dir string, move func(dir, path string) string) PatternSource {
+	movePaths := func(paths []string) []string {
+		moved := make([]string, len(paths))
+		for i, path := range paths {
+			moved[i] = path
+			if path != "-" {
+				moved[i] = move(dir, path)
+			}
+		}
+		return moved
+	}
+	s.Infiles, s.ImportPaths = movePaths(s.Infiles), movePaths(s.ImportPaths)
+	return s
+}
+
+// Picks up where a saved run left off, anything past the end of the pattern
+// as it is now is dropped. If the pattern has changed the place is found by
+// its row, and the counters and repeats worked move with it
+func (e *Engine) Resume(saved SavedStates) {
+	e.keep()
+	idx, shift := saved.StateIdx, 0
+	if saved.States != e.Len() && saved.Row > 0 {
+		log.Warnf("States file was saved with %d states but the pattern now has %d, progress may not line up",
+			saved.States, e.Len())
+		idx, shift = e.findRow(saved)
+	}
+	if dropped := e.restore(saved, shift); dropped > 0 {
+		log.Warnf("%d saved counter(s) and repeat(s) don't line up with the pattern and are dropped", dropped)
+	}
+	if idx < e.Len() {
+		e.StateIdx = idx
+	}
+}
+
+// The state of the saved row and how far it's moved. Rows after an open-ended
+// repeat count the passes worked again, so each way of lining the saved
+// repeats up with those of the pattern is tried until the row lands where
+// they say it should
+func (e *Engine) findRow(saved SavedStates) (int, int) {
+	e.restore(SavedStates{}, 0)
+	idx, ok := e.rowState(saved.Row)
+	if !ok {
+		return saved.StateIdx, 0
+	}
+	shifts := []int{idx - saved.StateIdx}
+	seen := map[int]bool{shifts[0]: true}
+	for _, now := range e.loopEnds() {
+		for end := range saved.Loops {
+			if shift := now - end; !seen[shift] {
+				seen[shift] = true
+				shifts = append(shifts, shift)
+			}
+		}
+	}
+	for _, shift := range shifts[1:] {
+		e.restore(saved, shift)
+		if at, ok := e.rowState(saved.Row); ok && at == saved.StateIdx+shift {
+			log.Infof("Picking up at row %d of the pattern", saved.Row)
+			return at, shift
+		}
+	}
+	log.Infof("Picking up at row %d of the pattern", saved.Row)
+	return idx, shifts[0]
+}
+
+// The saved counters and repeats worked, `shift` states on from where they
+// were saved, less those that no longer land on the pattern
+func (e *Engine) restore(saved SavedStates, shift int) int {
+	for end := range e.loops {
+		e.setLoop(end, Progress{})
+	}
+	e.counters = make(map[int]Counters)
+	dropped := 0
+	for idx, ctr := range saved.Counters {
+		if idx += shift; idx >= 0 && idx < e.Len() {
+			e.counters[idx] = ctr
+		} else {
+			dropped++
+		}
+	}
+	for end, p := range saved.Loops {
+		end += shift
+		if _, loopEnd, ok := e.loopAround(end); ok && loopEnd == end {
+			e.setLoop(end, p)
+		} else {
+			dropped++
+		}
+	}
+	return dropped
 }
 
 func (e *Engine) WriteEngine() error {
@@ -224,37 +816,101 @@ func (e *Engine) WriteEngine() error {
 		defer tmpFile.Close()
 		e.StatesFile = tmpFile.Name()
 	}
-	engineJson, err := json.MarshalIndent(e, "", "  ")
+	e.save()
+	dir, err := filepath.Abs(filepath.Dir(e.StatesFile))
+	if err != nil {
+		return err
+	}
+	source := e.Source.moved(dir, func(dir, path string) string {
+		if rel, err := filepath.Rel(dir, path); err == nil {
+			return rel
+		}
+		return path
+	})
+	engineJson, err := json.MarshalIndent(SavedStates{
+		Source:   source,
+		States:   e.Len(),
+		StateIdx: e.StateIdx,
+		Row:      e.State().Row,
+		Counters: e.counters,
+		Loops:    e.loops,
+	}, "", "  ")
 	if err == nil {
 		err = ioutil.WriteFile(e.StatesFile, engineJson, 0644)
 	}
 	return err
 }
 
+// ------------------ Moving ------------------
+
+// Going back from the first state of an open-ended repeat that's been worked
+// goes to the end of the previous pass
 func (e *Engine) PrevState() *CurrentState {
+	if start, end, ok := e.loopAround(e.StateIdx); ok && start == e.StateIdx && e.loops[end].Worked > 0 {
+		e.rework(end, -1)
+		e.StateIdx = end
+		return e.State()
+	}
 	if e.StateIdx == 0 {
-		return &e.States[e.StateIdx]
+		return e.State()
 	}
+	e.keep()
 	e.StateIdx -= 1
-	return &e.States[e.StateIdx]
+	if _, end, ok := e.loopAround(e.StateIdx); ok && end == e.StateIdx && e.loops[end].Done {
+		e.setLoop(end, Progress{Worked: e.loops[end].Worked - 1})
+	}
+	return e.State()
 }
 
+// The last state of an open-ended repeat goes back to its first, another pass,
+// until the condition is confirmed with `ConfirmState`
 func (e *Engine) NextState() *CurrentState {
-	if len(e.States)-1 == e.StateIdx {
-		return &e.States[e.StateIdx]
+	if loop := e.State(); loop.LoopLen > 0 {
+		start := e.StateIdx - loop.LoopLen + 1
+		e.rework(e.StateIdx, 1)
+		e.StateIdx = start
+		return e.State()
+	}
+	if e.Len()-1 <= e.StateIdx {
+		return e.State()
 	}
+	e.keep()
 	e.StateIdx += 1
-	return &e.States[e.StateIdx]
+	return e.State()
+}
+
+// The condition of the open-ended repeat ending on this state is met, so move
+// past it; false if not at the end of one
+func (e *Engine) ConfirmState() (*CurrentState, bool) {
+	loop := e.State()
+	if loop.LoopLen == 0 || loop.Done {
+		return loop, false
+	}
+	e.setLoop(e.StateIdx, Progress{Worked: loop.Worked + 1, Done: true})
+	if e.Len()-1 > e.StateIdx {
+		e.StateIdx += 1
+	}
+	return e.State(), true
+}
+
+// Moves every state of the repeat ending at `end` on (or back) a pass
+func (e *Engine) rework(end int, passes int) {
+	p := e.loops[end]
+	p.Worked += passes
+	e.setLoop(end, p)
 }
 
 func (e *Engine) GotoState(idx int) (*CurrentState, error) {
-	if idx >= 0 && len(e.States) > idx {
+	if idx >= 0 && e.Len() > idx {
+		e.keep()
 		e.StateIdx = idx
-		return &e.States[e.StateIdx], nil
+		return e.State(), nil
 	}
 	return nil, errors.New(fmt.Sprint("Invalid goto value: ", idx))
 }
 
+// ------------------ Forming ------------------
+
 func shorten(desc []string) string {
 	if len(desc) == 0 {
 		return ""
@@ -265,25 +921,60 @@ func shorten(desc []string) string {
 	return desc[0]
 }
 
-type IdxAndArgs struct {
-	idx  int
-	args []string
+// How many times what's started is repeated, from its one arg if it has one;
+// a count from the gauge for an open-ended repeat is only an estimate
+func (e *Engine) openRepeat(in Instr, rows bool) *repeat {
+	r := &repeat{name: in.Name, rows: rows}
+	if lc := in.Lc; len(lc.Args) == 1 {
+		if val, err := strconv.Atoi(lc.Args[0]); err == nil {
+			r.max = val
+		} else if lc.until {
+			r.max, r.approx, r.until = lc.approx, lc.approx > 0, lc.Args[0]
+		}
+	}
+	return r
 }
 
+// Worked until a condition is met rather than a set number of times; one
+// inside another isn't supported so the outer is worked once. The estimate of
+// an open-ended repeat is of rows, it's worked a pass at a time
+func (r *repeat) close() {
+	r.times = 1
+	if r.until != "" {
+		if r.worked > 1 {
+			r.max = (r.max + r.worked/2) / r.worked
+		}
+		if r.hasLoop {
+			log.Warnf("Repeat until %s holds another open-ended repeat, only the inner one is repeated", r.until)
+			return
+		}
+		r.loop = true
+		return
+	}
+	if r.max != 0 {
+		r.times = r.max
+	}
+}
+
+// Compiles the instructions into a program of each row once and the repeats they're
+// in, nothing is unrolled
 func (e *Engine) FormStates() {
 	nestedGroupCtr, nestedRowCtr := 0, 0
-	groupStartArr := make([]IdxAndArgs, 0)
-	rowStartArr := make([]IdxAndArgs, 0)
+	groupStartArr := make([]*repeat, 0)
+	rowStartArr := make([]*repeat, 0)
+	open := []*repeat{e.program}
 	state := MakeCurrentState()
-	for i := 0; i < len(e.engineData.Lines); i++ {
-		lc := e.engineData.Lines[i]
-		if lc.rowIsEqual(START_OF_BLOCK_LC) {
+Instrs:
+	for _, in := range e.engineData.Instrs {
+		lc := in.Lc
+		switch in.Kind {
+		case START_BLOCK_IK:
 			state.Desc.Block = strings.Join(lc.Desc, "\n")
 
-		} else if lc.rowIsEqual(END_OF_BLOCK_LC) {
-			break
+		case END_BLOCK_IK:
+			break Instrs
 
-		} else if lc.rowIsEqual(START_OF_GROUP_LC) {
+		case START_GROUP_IK:
 			log.WithFields(log.Fields{
 				"groupCtr": nestedGroupCtr,
 				"desc":     shorten(lc.Desc),
@@ -292,39 +983,28 @@ func (e *Engine) FormStates() {
 			if len(lc.Desc) != 0 {
 				state.Desc.Group = strings.Join(lc.Desc, "\n")
 			}
-			groupStartArr = append(groupStartArr, IdxAndArgs{
-				idx:  len(e.States),
-				args: lc.Args,
-			})
-			if len(lc.Args) == 1 {
-				if val, err := strconv.Atoi(lc.Args[0]); err == nil {
-					state.GroupMax = val
-				}
-			}
+			r := e.openRepeat(in, false)
+			groupStartArr = append(groupStartArr, r)
+			open = append(open, r)
 			nestedGroupCtr += 1
 
-		} else if lc.rowIsEqual(END_OF_GROUP_LC) {
+		case END_GROUP_IK:
 			lastIdx := len(groupStartArr) - 1
 			if lastIdx+1 != nestedGroupCtr {
 				panic("End of group reached, no groupStartIdxArr")
 			}
-			var gidxAndArgs IdxAndArgs
-			gidxAndArgs, groupStartArr = groupStartArr[lastIdx], groupStartArr[:lastIdx]
-			gmax := e.States[gidxAndArgs.idx].GroupMax
-			if gmax != 0 {
-				slice := append(make([]CurrentState, 0), e.States[gidxAndArgs.idx:len(e.States)]...)
-				for i := 0; i < gmax-1; i++ {
-					for idx := range slice {
-						slice[idx].GroupCtr += 1
-					}
-					e.States = append(e.States, slice...)
-				}
+			var r *repeat
+			r, groupStartArr = groupStartArr[lastIdx], groupStartArr[:lastIdx]
+			open = open[:len(open)-1]
+			if r.len > 0 {
+				r.close()
+				open[len(open)-1].addRepeat(r)
 			}
 			nestedGroupCtr -= 1
-			state.GroupMax = 1
+			state.GroupMax, state.GroupApprox, state.GroupUntil = 1, false, ""
 			log.WithField("groupCtr", nestedGroupCtr).Debug("[Engine.FormStates] ", strings.Repeat("  ", nestedGroupCtr), "End of group")
 
-		} else if lc.rowIsEqual(START_OF_ROW_LC) {
+		case START_ROW_IK:
 			log.WithFields(log.Fields{
 				"rowCtr": nestedRowCtr,
 				"desc":   shorten(lc.Desc),
@@ -332,50 +1012,41 @@ func (e *Engine) FormStates() {
 			if len(lc.Desc) > 0 {
 				state.Desc.Row = strings.Join(lc.Desc, "\n")
 			}
-			rowStartArr = append(rowStartArr, IdxAndArgs{
-				idx:  len(e.States),
-				args: lc.Args,
-			})
-			if len(lc.Args) == 1 {
-				if val, err := strconv.Atoi(lc.Args[0]); err == nil {
-					state.RowMax = val
-				}
-			}
+			r := e.openRepeat(in, true)
+			rowStartArr = append(rowStartArr, r)
+			open = append(open, r)
 			nestedRowCtr += 1
 
-		} else if lc.rowIsEqual(END_OF_ROW_LC) {
+		case END_ROW_IK:
 			lastIdx := len(rowStartArr) - 1
 			if lastIdx+1 != nestedRowCtr {
 				panic("End of row reached, no rowStartArr")
 			}
-			var idxAndArgs IdxAndArgs
-			idxAndArgs, rowStartArr = rowStartArr[lastIdx], rowStartArr[:lastIdx]
-			rmax := e.States[idxAndArgs.idx].RowMax
-			if rmax != 0 {
-				slice := append(make([]CurrentState, 0), e.States[idxAndArgs.idx:len(e.States)]...)
-				for i := 0; i < rmax-1; i++ {
-					for idx := range slice {
-						slice[idx].RowCtr += 1
-					}
-					e.States = append(e.States, slice...)
-				}
+			var r *repeat
+			r, rowStartArr = rowStartArr[lastIdx], rowStartArr[:lastIdx]
+			open = open[:len(open)-1]
+			if r.len > 0 {
+				r.close()
+				open[len(open)-1].addRepeat(r)
 			}
 			nestedRowCtr -= 1
-			state.RowMax = 1
+			state.RowMax, state.RowApprox, state.RowUntil = 1, false, ""
 			log.WithField("rowCtr", nestedRowCtr).Debug("[Engine.FormStates] ", strings.Repeat("  ", nestedRowCtr), "End of row")
 
-		} else {
-			if len(lc.Row) > 0 {
+		case ROW_IK:
+			if len(lc.Phrases) > 0 {
 				state.Lc = lc
 				state.HistRow = lc.prettyRow()
-				e.States = append(e.States, state)
+				e.steps = append(e.steps, state)
+				open[len(open)-1].addStep(len(e.steps)-1, &lc)
 			}
 		}
 	}
+	e.marks = nil
 }
 
 func (e *Engine) PrintEngine() {
-	for _, state := range e.States {
-		fmt.Println(state)
-	}
+	e.walk(func(idx int, s *CurrentState, err error) {
+		fmt.Println(*s)
+	})
 }
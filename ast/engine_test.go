@@ -0,0 +1,330 @@
+package ast_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bodneyc/knit-and-go/ast"
+	"github.com/bodneyc/knit-and-go/lexer"
+	"github.com/bodneyc/knit-and-go/parser"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.ErrorLevel)
+	os.Exit(m.Run())
+}
+
+// Cast on, an open-ended repeat with an increase in each pass, and enough rows
+// either side of it to pass a checkpoint
+const LOOP_PATTERN = `co(10)
+{ k(*) }(70)
+{ kfb k(*) }(3")
+{ k(*) }(70)
+`
+
+// The pattern lexed, parsed, walked and formed into states with the stitches
+// counted, every test of the engine starts from here
+func compile(t *testing.T, file string, text string) *ast.Engine {
+	l, err := lexer.NewLexerFromString(file, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := parser.NewParser(*l)
+	if err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	e := ast.NewEngineData()
+	p.WalkForLocals(e)
+	if err := p.WalkForLines(e); err != nil {
+		t.Fatal(err)
+	}
+	engine := ast.MakeEngine(e, "")
+	engine.FormStates()
+	engine.CountStitches()
+	return &engine
+}
+
+func compileFile(t *testing.T, file string) *ast.Engine {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compile(t, file, string(b))
+}
+
+func walked(e *ast.Engine) []ast.CurrentState {
+	states := make([]ast.CurrentState, 0, e.Len())
+	e.Walk(func(idx int, s *ast.CurrentState, err error) {
+		states = append(states, *s)
+	})
+	return states
+}
+
+// Backwards, so every state is found from a checkpoint rather than the one
+// before it
+func checkStateAt(t *testing.T, name string, e *ast.Engine) {
+	t.Helper()
+	want := walked(e)
+	if len(want) != e.Len() {
+		t.Fatalf("%s: walked %d states of %d", name, len(want), e.Len())
+	}
+	for i := e.Len() - 1; i >= 0; i-- {
+		if got := e.StateAt(i); !reflect.DeepEqual(got, want[i]) {
+			t.Fatalf("%s: state %d differs from the walk\ngot:\n%s\nwant:\n%s", name, i, got, want[i])
+		}
+	}
+}
+
+func loopEnd(t *testing.T, e *ast.Engine) int {
+	for i := 0; i < e.Len(); i++ {
+		if e.StateAt(i).LoopLen > 0 {
+			return i
+		}
+	}
+	t.Fatal("No open-ended repeat")
+	return -1
+}
+
+func TestStateAtMatchesWalk(t *testing.T) {
+	files, err := filepath.Glob("../test-patterns/*.knit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	more, err := filepath.Glob("../test-patterns/*/*.knit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range append(files, more...) {
+		if filepath.Base(file) == "comfy-raglan-erroring.knit" {
+			continue
+		}
+		checkStateAt(t, file, compileFile(t, file))
+	}
+	checkStateAt(t, "loop", compile(t, "loop.knit", LOOP_PATTERN))
+}
+
+// The samples are kept as they were written, mistakes and all, so those are
+// what's warned about
+var sampleWarnings = map[string][]string{
+	"multi-input.knit": {
+		"multi-input.knit:24:11: No marker m left on the needle to work up to",
+	},
+	"../test-patterns/diamond-blanket.knit": {
+		"../test-patterns/diamond-blanket.knit:21:5: Repeat of 2 stitches does not divide evenly into the 91 available",
+		"../test-patterns/diamond-blanket.knit:22:5: Repeat of 2 stitches does not divide evenly into the 91 available",
+		"../test-patterns/diamond-blanket.knit:27:7: Repeat of 12 stitches does not divide evenly into the 90 available",
+		"../test-patterns/diamond-blanket.knit:30:7: Repeat of 12 stitches does not divide evenly into the 83 available",
+		"../test-patterns/diamond-blanket.knit:33:7: Repeat of 13 stitches does not divide evenly into the 83 available",
+		"../test-patterns/diamond-blanket.knit:36:7: Repeat of 12 stitches does not divide evenly into the 83 available",
+		"../test-patterns/diamond-blanket.knit:39:7: Repeat of 12 stitches does not divide evenly into the 83 available",
+		"../test-patterns/diamond-blanket.knit:42:7: Repeat of 12 stitches does not divide evenly into the 83 available",
+		"../test-patterns/diamond-blanket.knit:45:7: Repeat of 12 stitches does not divide evenly into the 83 available",
+		"../test-patterns/diamond-blanket.knit:48:7: Repeat of 12 stitches does not divide evenly into the 83 available",
+		"../test-patterns/diamond-blanket.knit:51:7: Repeat of 12 stitches does not divide evenly into the 83 available",
+		"../test-patterns/diamond-blanket.knit:54:12: Repeat stops 14 stitches before the end but 11 are worked after it",
+	},
+	"../test-patterns/rsc/comfy-raglan.knit": {
+		"../test-patterns/rsc/comfy-raglan.knit:44:3: Stitches can't be counted from here, it isn't known how many of pick-up are worked",
+	},
+}
+
+// Each is checked as `knit` checks it by default, the multi-input pattern
+// with its aliases before it
+func TestPatternWarnings(t *testing.T) {
+	files, err := filepath.Glob("../test-patterns/*.knit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	more, err := filepath.Glob("../test-patterns/rsc/*.knit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := map[string]string{"multi-input.knit": ""}
+	for _, file := range append(files, more...) {
+		if filepath.Base(file) == "comfy-raglan-erroring.knit" {
+			continue
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		texts[file] = string(b)
+	}
+	for _, file := range []string{"aliases.knit", "pattern.knit"} {
+		b, err := ioutil.ReadFile(filepath.Join("../test-patterns/multi-input", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		texts["multi-input.knit"] += string(b)
+	}
+
+	for file, text := range texts {
+		e := compile(t, file, text)
+		errs := e.EngineData().CheckYarns()
+		errs = append(errs, e.CheckSides()...)
+		errs = append(errs, e.CountStitches()...)
+		errs = append(errs, e.CheckFloats(5)...)
+		got := make([]string, len(errs))
+		for i, err := range errs {
+			got[i] = err.Error()
+		}
+		if want := sampleWarnings[file]; len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Errorf("%s warns:\n%s\nwant:\n%s", file, strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	}
+}
+
+func TestStateAtAfterReworking(t *testing.T) {
+	e := compile(t, "loop.knit", LOOP_PATTERN)
+	end := loopEnd(t, e)
+	// Checkpoints past the repeat are made before it's worked again
+	checkStateAt(t, "before", e)
+
+	e.StateIdx = end
+	for i := 0; i < 5; i++ {
+		e.NextState()
+		if e.StateIdx != end {
+			t.Fatalf("Pass %d moved to state %d, not back to %d", i+2, e.StateIdx, end)
+		}
+	}
+	checkStateAt(t, "reworked", e)
+	if s := e.StateAt(end); s.Worked != 5 || s.Stitches != 16 {
+		t.Errorf("Six passes show %d worked with %d stitches, want 5 and 16", s.Worked, s.Stitches)
+	}
+
+	e.PrevState()
+	checkStateAt(t, "undone", e)
+	if s := e.StateAt(end); s.Stitches != 15 {
+		t.Errorf("Going back a pass shows %d stitches, want 15", s.Stitches)
+	}
+}
+
+func TestResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knit-and-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := compile(t, "loop.knit", LOOP_PATTERN)
+	end := loopEnd(t, e)
+	e.StateIdx = end
+	for i := 0; i < 3; i++ {
+		e.NextState()
+	}
+	e.StatesFile = filepath.Join(dir, "loop.json")
+	if err := e.WriteEngine(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := ast.ReadStatesFile(e.StatesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed := compile(t, "loop.knit", LOOP_PATTERN)
+	// Checkpoints made before resuming mustn't stand
+	resumed.StateAt(resumed.Len() - 1)
+	resumed.Resume(saved)
+	if resumed.StateIdx != e.StateIdx {
+		t.Errorf("Resumed at state %d, saved at %d", resumed.StateIdx, e.StateIdx)
+	}
+	checkStateAt(t, "resumed", resumed)
+	want := walked(e)
+	for i, s := range walked(resumed) {
+		if !reflect.DeepEqual(s, want[i]) {
+			t.Fatalf("Resumed state %d differs from the saved run\ngot:\n%s\nwant:\n%s", i, s, want[i])
+		}
+	}
+}
+
+// The place is kept by its row, so needles given before the cast-on move it a
+// state on, and the counters and passes worked move with it
+func TestResumeEditedPattern(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knit-and-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := compile(t, "loop.knit", LOOP_PATTERN)
+	end := loopEnd(t, e)
+	e.StateIdx = end
+	for i := 0; i < 3; i++ {
+		e.NextState()
+	}
+	e.GotoState(end + 5)
+	e.State().Ctr.Row = 4
+	e.StatesFile = filepath.Join(dir, "loop.json")
+	if err := e.WriteEngine(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := ast.ReadStatesFile(e.StatesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed := compile(t, "loop.knit", "use(4mm)\n"+LOOP_PATTERN)
+	resumed.Resume(saved)
+	if resumed.StateIdx != end+6 {
+		t.Errorf("Resumed at state %d, want %d", resumed.StateIdx, end+6)
+	}
+	if s := resumed.StateAt(end + 1); s.Worked != 3 {
+		t.Errorf("Repeat shows %d passes worked, want 3", s.Worked)
+	}
+	if s := resumed.StateAt(end + 6); s.Ctr.Row != 4 {
+		t.Errorf("Row counter is %d, want 4", s.Ctr.Row)
+	}
+}
+
+func TestReadOldStatesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knit-and-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "old.json")
+	if err := ioutil.WriteFile(file, []byte(`{"States": [{"StateIdx": 0}], "StateIdx": 0}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ast.ReadStatesFile(file); err == nil || !strings.Contains(err.Error(), "predates") {
+		t.Errorf("Reading a states file of every state gave %v", err)
+	}
+}
+
+func TestBreadcrumb(t *testing.T) {
+	text := "lace = {\n" + strings.Repeat("  k(*)\n", 20) + "}\n" +
+		"main = {\n  co(10)\n  lace(8)\n}\nmain\n"
+	e := compile(t, "lace.knit", text)
+	// The cast-on, two passes of lace, then six rows into the third
+	cases := map[int]string{
+		0:  "main",
+		47: "main > lace 3/8 > row 7/20",
+	}
+	for idx, want := range cases {
+		if got := e.StateAt(idx).Breadcrumb(); got != want {
+			t.Errorf("State %d shows %q, want %q", idx, got, want)
+		}
+	}
+}
+
+// Only setup stitches make a setup instruction, a row of yarn overs is worked
+// like any other
+func TestSetupIsNotARow(t *testing.T) {
+	e := compile(t, "setup.knit", "use(4mm)\nco(4)\npm\nyo(2)\nk(*)\nstitch wind { setup }\nwind\n")
+	want := []string{"setup", "setup", "setup", "row 1", "row 2", "setup"}
+	states := walked(e)
+	if len(states) != len(want) {
+		t.Fatalf("Walked %d states, want %d", len(states), len(want))
+	}
+	for i, s := range states {
+		if got := s.RowText(); got != want[i] {
+			t.Errorf("State %d is %q, want %q", i, got, want[i])
+		}
+	}
+}
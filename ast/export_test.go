@@ -0,0 +1,11 @@
+package ast
+
+// Every state in order, as the checks see them
+func (e *Engine) Walk(fn func(idx int, s *CurrentState, err error)) {
+	e.walk(fn)
+}
+
+// What the walk for lines found, for the checks run on it
+func (e *Engine) EngineData() *EngineData {
+	return e.engineData
+}
@@ -2,6 +2,7 @@ package ast
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	. "github.com/bodneyc/knit-and-go/lexer"
@@ -101,23 +102,22 @@ func (o *IdentExpr) Pos() Position { return o.At }
 // Only for assignment values
 func (o *IdentExpr) WalkForLines(e *EngineData, lc *LineContainer) error {
 	if assign := e.checkAssigns(o); assign != nil {
-		if err := (*assign).WalkForLines(e, lc); err != nil {
+		if err := assign.walkCall(e, lc, o.At, MakeBrackets()); err != nil {
 			return err
 		}
 	} else {
-		return fmt.Errorf("No assignment for %#v", o)
+		return ErrorAt(o.At, "", "No assignment for %s", o.Name)
 	}
 	return nil
 }
 
-func (o *IdentExpr) AliasForLines(e *EngineData, lc *LineContainer, size string) error {
+func (o *IdentExpr) AliasForLines(e *EngineData, lc *LineContainer, size string, p Phrase) error {
 	alias := e.checkAliases(*o)
-	aliasText := alias.Text(e)
-	fragment := aliasText
+	p.Kind, p.Text, p.Stitch = STITCH_PK, alias.Text(e), alias.Name
 	if size != "" {
-		fragment = fmt.Sprintf("%s %s", fragment, size)
+		p.Text = fmt.Sprintf("%s %s", p.Text, size)
 	}
-	lc.Row = append(lc.Row, fragment)
+	lc.addPhrase(p)
 	return nil
 }
 
@@ -125,9 +125,43 @@ func (o *IdentExpr) WalkForLocals(e *EngineData) {}
 
 // No assignment should exist if calling this function
 func (o *IdentExpr) Text(e *EngineData) string {
+	if param := e.checkParams(*o); param != nil {
+		return param.Text(e)
+	}
+	if n, ok := e.lookupNumber(o.Name); ok {
+		return strconv.Itoa(n)
+	}
 	return e.checkAliases(*o).Name
 }
 
+// ----------------- StringExpr ----------------
+
+// Quoted text, a note in a row or an argument such as `pu("along left edge")`,
+// shown as written
+type StringExpr struct {
+	At    Position `json:"at"`
+	Value string   `json:"value"`
+}
+
+func NewStringExpr(t TokenContainer) *StringExpr {
+	return &StringExpr{
+		At:    t.Pos,
+		Value: t.Str,
+	}
+}
+
+func (o *StringExpr) exprNode()     {}
+func (o *StringExpr) Pos() Position { return o.At }
+
+func (o *StringExpr) WalkForLocals(e *EngineData) {}
+func (o *StringExpr) Text(e *EngineData) string   { return o.Value }
+
+// A note worked as part of a row
+func (o *StringExpr) WalkForLines(e *EngineData, lc *LineContainer) error {
+	lc.addPhrase(Phrase{Kind: NOTE_PK, Text: o.Value})
+	return nil
+}
+
 // --------------- Single stitch ---------------
 
 type StitchExpr struct {
@@ -151,12 +185,47 @@ func (o *StitchExpr) WalkForLocals(e *EngineData) {}
 func (o *StitchExpr) Text(e *EngineData) string   { return "" }
 
 func (o *StitchExpr) WalkForLines(e *EngineData, lc *LineContainer) error {
-	if assign := e.checkAssigns(&o.Id); assign != nil {
-		o.Args.WalkForLines(e, lc)
-		(*assign).WalkForLines(e, lc)
+	if err := e.checkDeclared(o.Id); err != nil {
+		return err
+	}
+	if err := e.checkArgsDeclared(o.Args); err != nil {
+		return err
+	}
+	if err := e.checkVariants(o.Args); err != nil {
+		return err
+	}
+	if err := e.checkNumbers(o.Args); err != nil {
+		return err
+	}
+	id := o.Id
+	if param, ok := e.checkParams(id).(*IdentExpr); ok {
+		id = *param
+	}
+	if assign := e.checkAssigns(&id); assign != nil {
+		if err := assign.walkCall(e, lc, o.At, o.Args); err != nil {
+			return fmt.Errorf("%w%s", err, util.StackLine())
+		}
 	} else {
+		if err := checkDepth(o.Args, o.At, id.Name); err != nil {
+			return err
+		}
 		size := o.Args.GetSizeText(e)
-		o.Id.AliasForLines(e, lc, size)
+		def, known := e.lookupStitch(id)
+		// The needles for `use(5mm)` aren't a number of stitches
+		if len(o.Args.Args) == 1 && !def.worksNothing() {
+			if n, ok := e.measure(o.Args.Args[0], false); ok {
+				size = fmt.Sprintf("%s (~%d)", size, n)
+			}
+		}
+		p := Phrase{At: id.Pos()}
+		if known {
+			p.Def = &def
+		}
+		p.Count = e.countStitch(p.Def, o.Args)
+		if y, ok := e.yarnFor(o.Args); ok {
+			p.Yarn, p.Colour = y.name, y.hex
+		}
+		id.AliasForLines(e, lc, size, p)
 	}
 	return nil
 }
@@ -235,6 +304,10 @@ func (o *SizeExpr) Text(e *EngineData) string {
 		s = fmt.Sprintf("%d", o.Ni)
 	} else if o.Nf != -1 {
 		s = fmt.Sprintf("%.2f", o.Nf)
+	} else if param := e.checkParams(o.Id); param != nil {
+		s = param.Text(e)
+	} else if n, ok := e.lookupNumber(o.Id.Name); ok {
+		s = strconv.Itoa(n)
 	} else if o.Id.Name != "" {
 		s = e.checkAliases(o.Id).Name
 	}
@@ -260,6 +333,41 @@ func (o *SizeExpr) Text(e *EngineData) string {
 	return s
 }
 
+// ---------------- VariantExpr ----------------
+
+// One value per size of the pattern, e.g. `88 | 96 | 104 | 112`
+type VariantExpr struct {
+	At     Position    `json:"at"`
+	Values []*SizeExpr `json:"values"`
+}
+
+func NewVariantExpr(values []*SizeExpr) *VariantExpr {
+	return &VariantExpr{
+		At:     values[0].Pos(),
+		Values: values,
+	}
+}
+
+func (o *VariantExpr) exprNode()     {}
+func (o *VariantExpr) Pos() Position { return o.At }
+
+func (o *VariantExpr) WalkForLocals(e *EngineData) {}
+
+func (o *VariantExpr) WalkForLines(e *EngineData, lc *LineContainer) error { return nil }
+
+// Should the variant not fit the sizes, fall back to the first value and leave
+// the error to `checkVariants`
+func (o *VariantExpr) selected(e *EngineData) *SizeExpr {
+	if len(o.Values) != len(e.sizes) {
+		return o.Values[0]
+	}
+	return o.Values[e.sizeIdx]
+}
+
+func (o *VariantExpr) Text(e *EngineData) string {
+	return o.selected(e).Text(e)
+}
+
 // -------------------- Row --------------------
 
 type RowExpr struct {
@@ -280,26 +388,46 @@ func (o *RowExpr) Pos() Position { return o.Stitches[0].Pos() }
 func (o *RowExpr) Text(e *EngineData) string { return "" }
 
 func (o *RowExpr) WalkForLines(e *EngineData, lc *LineContainer) error {
-	if !e.nestedRow {
-		o.Args.WalkForLines(e, lc)
+	o.Args.WalkForLines(e, lc)
+	return o.walkStitches(e, lc)
+}
+
+// The args of a row in braces are on the closing brace rather than the row's
+func (o *RowExpr) walkStitches(e *EngineData, lc *LineContainer) error {
+	if err := checkDepth(o.Args, o.Pos(), "a row"); err != nil {
+		return err
+	}
+	if err := e.checkVariants(o.Args); err != nil {
+		return err
+	}
+	if err := e.checkNumbers(o.Args); err != nil {
+		return err
+	}
+	for _, arg := range o.Args.Args {
+		if y, ok := e.yarnArg(arg); ok {
+			e.yarns = append(e.yarns, y)
+			defer func() { e.yarns = e.yarns[:len(e.yarns)-1] }()
+			break
+		}
 	}
 	for _, stitch := range o.Stitches {
 		switch stitch.(type) {
 		case *RowExpr:
 			rowExpr := stitch.(*RowExpr)
-			lc.Row = append(lc.Row, "{")
-			e.nestedRow = true
-			e.nestedLevel += 1
-			if err := stitch.WalkForLines(e, lc); err != nil {
+			lc.addPhrase(Phrase{Kind: OPEN_PK, Text: "{", At: rowExpr.Pos()})
+			if err := rowExpr.walkStitches(e, lc); err != nil {
 				return fmt.Errorf("%w%s", err, util.StackLine())
 			}
-			e.nestedLevel -= 1
-			e.nestedRow = false
 			endWrap := "}"
 			if size := rowExpr.Args.GetSizeText(e); size != "" {
 				endWrap = fmt.Sprintf("%s %s", endWrap, size)
 			}
-			lc.Row = append(lc.Row, endWrap)
+			lc.addPhrase(Phrase{
+				Kind:  CLOSE_PK,
+				Text:  endWrap,
+				Count: e.countFrom(rowExpr.Args, true),
+				At:    rowExpr.Pos(),
+			})
 		default:
 			if err := stitch.WalkForLines(e, lc); err != nil {
 				return fmt.Errorf("%w%s", err, util.StackLine())
@@ -339,6 +467,8 @@ func (o *GroupExpr) Pos() Position { return o.LBrace }
 func (o *GroupExpr) Text(e *EngineData) string { return "" }
 
 func (o *GroupExpr) WalkForLines(e *EngineData, lc *LineContainer) error {
+	e.pushScope(o)
+	defer e.popScope()
 	for _, line := range o.Lines {
 		if err := line.WalkForLines(e); err != nil {
 			return fmt.Errorf("%w%s", err, util.StackLine())
@@ -348,6 +478,8 @@ func (o *GroupExpr) WalkForLines(e *EngineData, lc *LineContainer) error {
 }
 
 func (o *GroupExpr) WalkForLocals(e *EngineData) {
+	e.pushOwner(o)
+	defer e.popOwner()
 	for _, line := range o.Lines {
 		line.WalkForLocals(e)
 	}
@@ -0,0 +1,125 @@
+package ast
+
+import (
+	"math"
+
+	. "github.com/bodneyc/knit-and-go/lexer"
+)
+
+// ----------------- GaugeStmt -----------------
+
+// Stitches and rows over a length, e.g. `gauge(22 sts, 30 rows, 10cm)`, either
+// count may be left out (0)
+type GaugeStmt struct {
+	At       Position         `json:"at"`
+	Stitches float64          `json:"stitches"`
+	Rows     float64          `json:"rows"`
+	Over     *SizeExpr        `json:"over"`
+	Desc     CommentGroupExpr `json:"desc"`
+}
+
+func NewGaugeStmt(desc CommentGroupExpr, at Position, stitches float64, rows float64, over *SizeExpr) *GaugeStmt {
+	return &GaugeStmt{
+		At:       at,
+		Stitches: stitches,
+		Rows:     rows,
+		Over:     over,
+		Desc:     desc,
+	}
+}
+
+func (s *GaugeStmt) stmtNode()     {}
+func (s *GaugeStmt) Pos() Position { return s.At }
+
+func (s *GaugeStmt) WalkForLines(e *EngineData) error {
+	if s.Over == nil || !s.Over.Unit.isLength() || s.Over.Nf <= 0 {
+		return ErrorAt(s.Pos(), "", "Gauge must be over a length")
+	}
+	e.scope.gauge = s
+	return nil
+}
+
+func (s *GaugeStmt) WalkForLocals(e *EngineData) {}
+
+// ------------------ Measurements ------------------
+
+func (u MeasurementUnit) isLength() bool {
+	switch u {
+	case MM, CM, INCHES, FEET:
+		return true
+	}
+	return false
+}
+
+func (u MeasurementUnit) millimetres() float64 {
+	switch u {
+	case CM:
+		return 10
+	case INCHES:
+		return 25.4
+	case FEET:
+		return 304.8
+	}
+	return 1
+}
+
+func (s *Scope) lookupGauge() *GaugeStmt {
+	for ; s != nil; s = s.parent {
+		if s.gauge != nil {
+			return s.gauge
+		}
+	}
+	return nil
+}
+
+// Rows, or stitches, to the nearest whole one in a measurement at the gauge in
+// scope, there's always at least one
+func (e *EngineData) measure(arg Expr, rows bool) (int, bool) {
+	size, ok := e.substituteParam(arg).(*SizeExpr)
+	if !ok || !size.Unit.isLength() || size.Before || size.Nf < 0 {
+		return 0, false
+	}
+	gauge := e.scope.lookupGauge()
+	if gauge == nil {
+		return 0, false
+	}
+	per := gauge.Stitches
+	if rows {
+		per = gauge.Rows
+	}
+	if per <= 0 {
+		return 0, false
+	}
+	length := size.Nf * size.Unit.millimetres()
+	over := gauge.Over.Nf * gauge.Over.Unit.millimetres()
+	n := int(math.Round(length / over * per))
+	if n < 1 {
+		n = 1
+	}
+	return n, true
+}
+
+// Repeats of a row or group given as a single measurement, 0 if there's no
+// measurement or no gauge for it
+func (e *EngineData) measureRows(args Brackets) int {
+	if len(args.Args) != 1 {
+		return 0
+	}
+	n, _ := e.measure(args.Args[0], true)
+	return n
+}
+
+// Repeats of a row or group until a measurement, `(19")`, or a condition,
+// `("until the armhole measures 8in")`, are open-ended
+func (e *EngineData) isOpenEnded(args Brackets) bool {
+	if len(args.Args) != 1 {
+		return false
+	}
+	switch arg := e.substituteParam(args.Args[0]).(type) {
+	case *SizeExpr:
+		return arg.Unit.isLength() && !arg.Before
+	case *StringExpr:
+		return true
+	}
+	return false
+}
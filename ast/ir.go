@@ -0,0 +1,115 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/bodneyc/knit-and-go/lexer"
+)
+
+// ------------------ Instructions ------------------
+
+// What the walk for lines emits, rows along with the start and end of each
+// block, group and row repeat they're in
+type InstrKind int
+
+const (
+	ROW_IK InstrKind = iota
+	START_BLOCK_IK
+	END_BLOCK_IK
+	START_GROUP_IK
+	END_GROUP_IK
+	START_ROW_IK
+	END_ROW_IK
+)
+
+func (k InstrKind) String() string {
+	switch k {
+	case ROW_IK:
+		return "ROW"
+	case START_BLOCK_IK:
+		return "START: BLOCK"
+	case END_BLOCK_IK:
+		return "END: BLOCK"
+	case START_GROUP_IK:
+		return "START: GROUP"
+	case END_GROUP_IK:
+		return "END: GROUP"
+	case START_ROW_IK:
+		return "START: ROW"
+	case END_ROW_IK:
+		return "END: ROW"
+	}
+	return fmt.Sprintf("InstrKind(%d)", int(k))
+}
+
+// A row to be worked, or for a start its description and how many times
+// what's in it is repeated, and the assignment called if it's a call; an end
+// has nothing
+type Instr struct {
+	Kind InstrKind
+	Lc   LineContainer
+	Name string
+}
+
+func (e *EngineData) emit(kind InstrKind, lc LineContainer) {
+	e.Instrs = append(e.Instrs, Instr{Kind: kind, Lc: lc})
+}
+
+func (e *EngineData) PrintLines() {
+	for _, in := range e.Instrs {
+		if len(in.Lc.Desc) > 0 {
+			fmt.Println(strings.Join(in.Lc.Desc, "\n"))
+		}
+		if in.Kind != ROW_IK {
+			fmt.Println(in.Kind)
+		} else if len(in.Lc.Phrases) > 0 {
+			fmt.Printf("row: %s\n", in.Lc.prettyRow())
+		}
+		if len(in.Lc.Args) > 0 {
+			fmt.Printf(" args: %s\n", strings.Join(in.Lc.Args, ", "))
+		}
+	}
+}
+
+// ------------------ Phrases ------------------
+
+type PhraseKind int
+
+const (
+	STITCH_PK PhraseKind = iota
+	// Quoted text worked as part of the row
+	NOTE_PK
+	// The braces around stitches repeated within a row, the count is on the
+	// closing one
+	OPEN_PK
+	CLOSE_PK
+)
+
+// How many times a stitch, or stitches in braces, are worked; `N` is in
+// stitches once any measurement has been worked out from the gauge, `Unit` is
+// what was written
+type Count struct {
+	Kind   CountKind
+	N      int
+	Unit   MeasurementUnit
+	Marker string
+}
+
+// A fragment of a row, `Text` is how it's shown, a stitch has the name it's
+// worked as once aliases are followed and its definition, if it has one. The
+// yarn is that it's worked in, with its hex
+type Phrase struct {
+	Kind   PhraseKind
+	Text   string
+	Stitch string
+	Count  Count
+	At     Position
+	Def    *StitchDef `json:",omitempty"`
+	Yarn   string     `json:",omitempty"`
+	Colour string     `json:",omitempty"`
+}
+
+func (o *LineContainer) addPhrase(p Phrase) {
+	o.Phrases = append(o.Phrases, p)
+}
@@ -0,0 +1,296 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ------------------ Markers ------------------
+
+// A stitch marker with `At` stitches before it on the needle, unnamed markers,
+// from a bare `pm`, match any name
+type Marker struct {
+	Name string
+	At   int
+}
+
+func (m Marker) matches(name string) bool {
+	return m.Name == "" || name == "" || m.Name == name
+}
+
+func (m Marker) String() string {
+	name := m.Name
+	if name == "" {
+		name = "marker"
+	}
+	return fmt.Sprintf("%s@%d", name, m.At)
+}
+
+// A name given as a stitch count is a marker if it's an alias of `marker`,
+// e.g. `m := marker`
+func (e *EngineData) isMarker(id IdentExpr) bool {
+	return id.Name != "" && strings.EqualFold(e.checkAliases(id).Name, "marker")
+}
+
+// `sl(2)` is just stitches but `sl(m)` slips the marker, `pm` works no
+// stitches so it's always a marker
+func (c *countElem) markerRole() MarkerRole {
+	if c.def.Marker == NO_MARKER {
+		return NO_MARKER
+	}
+	if c.spec.Kind == MARKER_CK || c.def.Consumes == 0 && c.def.Produces == 0 {
+		return c.def.Marker
+	}
+	return NO_MARKER
+}
+
+func (c *countElem) usesMarkers() bool {
+	if c.children == nil {
+		return c.spec.Kind == UNTIL_MARKER_CK || c.markerRole() != NO_MARKER
+	}
+	for _, child := range c.children {
+		if child.usesMarkers() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *countElem) worksToMarker() bool {
+	if c.children == nil {
+		return c.spec.Kind == UNTIL_MARKER_CK
+	}
+	for _, child := range c.children {
+		if child.worksToMarker() {
+			return true
+		}
+	}
+	return false
+}
+
+// Works the row a stitch at a time to move the markers along with the
+// stitches, a count that only needs the markers to be known comes from here,
+// otherwise `apply` has the final say
+func (c *countElem) applyWithMarkers(live int, markers []Marker) (int, []Marker, error) {
+	if live < 0 {
+		live, err := c.apply(live)
+		return live, nil, err
+	}
+	w := &worker{
+		live:  live,
+		ahead: append(make([]Marker, 0, len(markers)), markers...),
+	}
+	w.work(c, 0)
+	after, markers := w.finish()
+
+	if c.worksToMarker() {
+		if w.cons > live {
+			return -1, nil, fmt.Errorf("%s: Row works %d stitches but only %d are on the needle",
+				c.at.Str(), w.cons, live)
+		}
+		if w.stuck {
+			return -1, nil, w.err
+		}
+		return after, markers, w.err
+	}
+	after, err := c.apply(live)
+	if err == nil {
+		err = w.err
+	}
+	if w.stuck || after < 0 {
+		markers = nil
+	}
+	return after, markers, err
+}
+
+// Where the working of a row has got to
+type worker struct {
+	live       int
+	cons, prod int
+	// Still on the left needle, and moved to the right
+	ahead, behind []Marker
+	err           error
+	// The rest of the row can't be known
+	stuck bool
+	// How far each yarn has been carried, only when checking floats
+	floats    map[string]int
+	maxFloat  int
+	floatErrs []error
+}
+
+func (w *worker) warn(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *worker) fail(err error) {
+	w.warn(err)
+	w.stuck = true
+}
+
+// Any marker reached moves across with the stitches
+func (w *worker) carry() {
+	for len(w.ahead) > 0 && w.ahead[0].At <= w.cons {
+		w.behind = append(w.behind, Marker{Name: w.ahead[0].Name, At: w.prod})
+		w.ahead = w.ahead[1:]
+	}
+}
+
+func (w *worker) stitch(c *countElem) {
+	w.carry()
+	w.cons += c.def.Consumes
+	w.prod += c.def.Produces
+	w.strand(c)
+}
+
+// The first marker still to come that matches
+func (w *worker) nextMarker(name string) (Marker, bool) {
+	for _, m := range w.ahead {
+		if m.At >= w.cons && m.matches(name) {
+			return m, true
+		}
+	}
+	return Marker{}, false
+}
+
+// Unworked stitches are left on the needle, markers and all
+func (w *worker) finish() (int, []Marker) {
+	w.carry()
+	markers := w.behind
+	for _, m := range w.ahead {
+		markers = append(markers, Marker{Name: m.Name, At: w.prod + m.At - w.cons})
+	}
+	return w.prod + w.live - w.cons, markers
+}
+
+// `tail` is what's worked after this element, so where a repeat to the end
+// stops
+func (w *worker) work(c *countElem, tail int) {
+	if w.stuck {
+		return
+	}
+	if c.children == nil {
+		w.workStitch(c, tail)
+		return
+	}
+	switch c.spec.Kind {
+	case FIXED_CK:
+		for i := 0; i < c.spec.N && !w.stuck; i++ {
+			w.workChildren(c, tail)
+		}
+	case TO_END_CK, BEFORE_END_CK:
+		end := w.live - tail
+		if c.spec.Kind == BEFORE_END_CK {
+			end = w.live - c.spec.N
+		}
+		for w.cons < end && !w.stuck {
+			from := w.cons
+			w.workChildren(c, tail)
+			if w.cons == from {
+				w.stuck = true
+			}
+		}
+	default:
+		w.stuck = true
+	}
+}
+
+func (w *worker) workChildren(c *countElem, tail int) {
+	for i, child := range c.children {
+		w.work(child, child.tailIn(c.children[i+1:], tail))
+	}
+}
+
+// What's worked after an element, `after` it in its group then `tail`, before
+// where it stops; working up to a marker, `Kfb K(-m) Kfb sm`, it's only what's
+// worked before the marker's next met
+func (c *countElem) tailIn(after []*countElem, tail int) int {
+	toMarker := c.children == nil && c.spec.Kind == UNTIL_MARKER_CK
+	n := 0
+	for _, next := range after {
+		if toMarker && next.usesMarkers() {
+			return n
+		}
+		if l, ok := next.linear(); ok && l.open == nil {
+			n += l.cons
+		}
+	}
+	if toMarker {
+		return 0
+	}
+	return tail + n
+}
+
+func (w *worker) workStitch(c *countElem, tail int) {
+	switch c.markerRole() {
+	case PLACE_MARKER:
+		w.carry()
+		w.behind = append(w.behind, Marker{Name: c.spec.Marker, At: w.prod})
+		return
+	case SLIP_MARKER, REMOVE_MARKER:
+		idx := -1
+		for i, m := range w.ahead {
+			if m.At == w.cons && m.matches(c.spec.Marker) {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			w.warn(fmt.Errorf("%s: No marker to %s after %d stitches",
+				c.at.Str(), c.markerRole(), w.cons))
+			return
+		}
+		m := w.ahead[idx]
+		w.ahead = append(w.ahead[:idx:idx], w.ahead[idx+1:]...)
+		if c.markerRole() == SLIP_MARKER {
+			w.carry()
+			w.behind = append(w.behind, Marker{Name: m.Name, At: w.prod})
+		}
+		return
+	}
+
+	if !c.known {
+		w.stuck = true
+		return
+	}
+
+	end := -1
+	switch c.spec.Kind {
+	case FIXED_CK:
+		for i := 0; i < c.spec.N; i++ {
+			w.stitch(c)
+		}
+	case TO_END_CK:
+		end = w.live - tail
+	case BEFORE_END_CK:
+		end = w.live - c.spec.N
+	case UNTIL_MARKER_CK:
+		m, ok := w.nextMarker(c.spec.Marker)
+		if !ok {
+			w.fail(fmt.Errorf("%s: No marker %s left on the needle to work up to",
+				c.at.Str(), c.spec.Marker))
+			return
+		}
+		end = m.At - tail
+	default:
+		w.stuck = true
+		return
+	}
+	if end < 0 {
+		return
+	}
+	if c.def.Consumes == 0 {
+		w.stuck = true
+		return
+	}
+
+	from := w.cons
+	for w.cons+c.def.Consumes <= end {
+		w.stitch(c)
+	}
+	if w.cons != end {
+		w.warn(fmt.Errorf("%s: Repeat of %d stitches does not divide evenly into the %d available",
+			c.at.Str(), c.def.Consumes, end-from))
+	}
+}
@@ -92,6 +92,17 @@ func (o *IdentExpr) MarshalJSON() ([]byte, error) {
 	})
 }
 
+func (o *StringExpr) MarshalJSON() ([]byte, error) {
+	type Copy StringExpr
+	return json.Marshal(&struct {
+		Type string `json:"type"`
+		*Copy
+	}{
+		Type: "StringExpr",
+		Copy: (*Copy)(o),
+	})
+}
+
 func (o *ImportStmt) MarshalJSON() ([]byte, error) {
 	type Copy ImportStmt
 	return json.Marshal(&struct {
@@ -136,6 +147,17 @@ func (o *SizeExpr) MarshalJSON() ([]byte, error) {
 	})
 }
 
+func (o *StitchStmt) MarshalJSON() ([]byte, error) {
+	type Copy StitchStmt
+	return json.Marshal(&struct {
+		Type string `json:"type"`
+		*Copy
+	}{
+		Type: "StitchStmt",
+		Copy: (*Copy)(o),
+	})
+}
+
 func (o *StitchExpr) MarshalJSON() ([]byte, error) {
 	type Copy StitchExpr
 	return json.Marshal(&struct {
@@ -146,3 +168,69 @@ func (o *StitchExpr) MarshalJSON() ([]byte, error) {
 		Copy: (*Copy)(o),
 	})
 }
+
+func (o *SizesStmt) MarshalJSON() ([]byte, error) {
+	type Copy SizesStmt
+	return json.Marshal(&struct {
+		Type string `json:"type"`
+		*Copy
+	}{
+		Type: "SizesStmt",
+		Copy: (*Copy)(o),
+	})
+}
+
+func (o *VariantExpr) MarshalJSON() ([]byte, error) {
+	type Copy VariantExpr
+	return json.Marshal(&struct {
+		Type string `json:"type"`
+		*Copy
+	}{
+		Type: "VariantExpr",
+		Copy: (*Copy)(o),
+	})
+}
+
+func (o *ArithExpr) MarshalJSON() ([]byte, error) {
+	type Copy ArithExpr
+	return json.Marshal(&struct {
+		Type string `json:"type"`
+		*Copy
+	}{
+		Type: "ArithExpr",
+		Copy: (*Copy)(o),
+	})
+}
+
+func (o *NumberStmt) MarshalJSON() ([]byte, error) {
+	type Copy NumberStmt
+	return json.Marshal(&struct {
+		Type string `json:"type"`
+		*Copy
+	}{
+		Type: "NumberStmt",
+		Copy: (*Copy)(o),
+	})
+}
+
+func (o *ConstructionStmt) MarshalJSON() ([]byte, error) {
+	type Copy ConstructionStmt
+	return json.Marshal(&struct {
+		Type string `json:"type"`
+		*Copy
+	}{
+		Type: "ConstructionStmt",
+		Copy: (*Copy)(o),
+	})
+}
+
+func (o *GaugeStmt) MarshalJSON() ([]byte, error) {
+	type Copy GaugeStmt
+	return json.Marshal(&struct {
+		Type string `json:"type"`
+		*Copy
+	}{
+		Type: "GaugeStmt",
+		Copy: (*Copy)(o),
+	})
+}
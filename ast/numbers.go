@@ -0,0 +1,197 @@
+package ast
+
+import (
+	"fmt"
+	"strconv"
+
+	. "github.com/bodneyc/knit-and-go/lexer"
+	"github.com/bodneyc/knit-and-go/util"
+)
+
+// ----------------- ArithExpr -----------------
+
+type ArithOp string
+
+const (
+	ADD_OP ArithOp = "+"
+	SUB_OP ArithOp = "-"
+	MUL_OP ArithOp = "*"
+	DIV_OP ArithOp = "/"
+)
+
+// Binary arithmetic in brackets or the rhs of a number, `At` is the operator
+type ArithExpr struct {
+	At Position `json:"at"`
+	Op ArithOp  `json:"op"`
+	X  Expr     `json:"x"`
+	Y  Expr     `json:"y"`
+}
+
+func NewArithExpr(op TokenContainer, x Expr, y Expr) *ArithExpr {
+	return &ArithExpr{
+		At: op.Pos,
+		Op: ArithOp(op.Str),
+		X:  x,
+		Y:  y,
+	}
+}
+
+func (o *ArithExpr) exprNode()     {}
+func (o *ArithExpr) Pos() Position { return o.At }
+
+func (o *ArithExpr) WalkForLocals(e *EngineData) {}
+
+func (o *ArithExpr) WalkForLines(e *EngineData, lc *LineContainer) error { return nil }
+
+// Errors are left to `checkNumbers`
+func (o *ArithExpr) Text(e *EngineData) string {
+	n, err := e.evalNumber(o)
+	if err != nil {
+		return "?"
+	}
+	return strconv.Itoa(n)
+}
+
+// ----------------- NumberStmt ----------------
+
+// A named whole number, e.g. `sts = 96` or `half = sts / 2`, worked out when
+// the walk for lines reaches it
+type NumberStmt struct {
+	Lhs  IdentExpr        `json:"lhs"`
+	Rhs  Expr             `json:"rhs"`
+	Desc CommentGroupExpr `json:"desc"`
+}
+
+func NewNumberStmt(desc CommentGroupExpr, lhs IdentExpr, rhs Expr) *NumberStmt {
+	return &NumberStmt{
+		Lhs:  lhs,
+		Rhs:  rhs,
+		Desc: desc,
+	}
+}
+
+func (s *NumberStmt) stmtNode()     {}
+func (s *NumberStmt) Pos() Position { return s.Lhs.Pos() }
+
+func (s *NumberStmt) WalkForLines(e *EngineData) error {
+	n, err := e.evalNumber(s.Rhs)
+	if err != nil {
+		return fmt.Errorf("%w%s", err, util.StackLine())
+	}
+	e.declareNumber(s.Lhs, n)
+	return nil
+}
+
+func (s *NumberStmt) WalkForLocals(e *EngineData) {
+	e.declareLocal(s.Lhs)
+}
+
+// ------------------ Evaluation ------------------
+
+func makeNumber(at Position, n int) *SizeExpr {
+	return &SizeExpr{
+		At:     at,
+		Ni:     int64(n),
+		Nf:     float64(n),
+		Id:     IdentExpr{At: at, Name: strconv.Itoa(n)},
+		Before: false,
+		Unit:   NOUNIT,
+	}
+}
+
+func (e *EngineData) lookupNumber(name string) (int, bool) {
+	if name == "" {
+		return 0, false
+	}
+	return e.scope.lookupNumber(name)
+}
+
+func (e *EngineData) evalNumber(x Expr) (int, error) {
+	switch x.(type) {
+	case *ArithExpr:
+		return e.evalArith(x.(*ArithExpr))
+
+	case *VariantExpr:
+		if err := e.checkVariants(Brackets{Args: []Expr{x}}); err != nil {
+			return 0, err
+		}
+		return e.evalNumber(x.(*VariantExpr).selected(e))
+
+	case *SizeExpr:
+		size := x.(*SizeExpr)
+		if size.Unit != NOUNIT || size.Before {
+			return 0, ErrorAt(size.Pos(), "", "%s is not a plain number", size.Text(e))
+		}
+		if size.Ni >= 0 {
+			return int(size.Ni), nil
+		}
+		if size.Nf >= 0 {
+			return 0, ErrorAt(size.Pos(), "", "%s is not a whole number", size.Text(e))
+		}
+		return e.evalNumber(&size.Id)
+
+	case *IdentExpr:
+		ident := x.(*IdentExpr)
+		if param := e.checkParams(*ident); param != nil {
+			return e.evalNumber(param)
+		}
+		if n, ok := e.lookupNumber(ident.Name); ok {
+			return n, nil
+		}
+		if err := e.checkDeclared(*ident); err != nil {
+			return 0, err
+		}
+		return 0, ErrorAt(ident.Pos(), "", "%s is not a number", ident.Name)
+	}
+	return 0, ErrorAt(x.Pos(), "", "Not a number")
+}
+
+// Stitch and row counts are whole and can't be negative, so neither can any
+// part of working one out
+func (e *EngineData) evalArith(o *ArithExpr) (int, error) {
+	x, err := e.evalNumber(o.X)
+	if err != nil {
+		return 0, err
+	}
+	y, err := e.evalNumber(o.Y)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	switch o.Op {
+	case ADD_OP:
+		n = x + y
+	case SUB_OP:
+		n = x - y
+	case MUL_OP:
+		n = x * y
+	case DIV_OP:
+		if y == 0 {
+			return 0, ErrorAt(o.Pos(), "", "%d / 0 divides by zero", x)
+		}
+		if x%y != 0 {
+			return 0, ErrorAt(o.Pos(), e.divideHint(o), "%d / %d does not divide evenly, leaving %d", x, y, x%y)
+		}
+		n = x / y
+	default:
+		return 0, ErrorAt(o.Pos(), "", "Unknown operator %s", o.Op)
+	}
+
+	if n < 0 {
+		return 0, ErrorAt(o.Pos(), "", "%d %s %d is negative (%d)", x, o.Op, y, n)
+	}
+	return n, nil
+}
+
+// Any arithmetic in the args must work out
+func (e *EngineData) checkNumbers(args Brackets) error {
+	for _, arg := range args.Args {
+		if arith, ok := arg.(*ArithExpr); ok {
+			if _, err := e.evalArith(arith); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package ast
+
+import (
+	"sort"
+)
+
+// ------------------ Program ------------------
+
+// Part of a repeat, either the state at `step` or a repeat within it
+type part struct {
+	step   int
+	repeat *repeat
+}
+
+// Rows worked `times` times over, or if it's a `loop` until a condition is met;
+// `len` states to a pass, `flat` of them worked flat and `worked` of them rows
+// rather than setup. Named for the assignment if it's a call
+type repeat struct {
+	name    string
+	rows    bool
+	max     int
+	approx  bool
+	until   string
+	times   int
+	loop    bool
+	hasLoop bool
+	parts   []part
+	// Where each part starts in a pass, and the rows worked before it
+	starts []int
+	before []int
+	len    int
+	flat   int
+	worked int
+}
+
+// The repeat as the state on pass `iter` sees it, passes of an open-ended one
+// are counted from how far it's been worked
+func (r *repeat) level(iter int) Level {
+	l := Level{Name: r.name, Called: r.name != "", Rows: r.rows, Ctr: iter + 1, Max: r.max}
+	switch {
+	case r.name != "":
+	case r.rows:
+		l.Name = "row"
+	default:
+		l.Name = "group"
+	}
+	if r.loop {
+		l.Approx, l.Until = r.approx, r.until
+	}
+	return l
+}
+
+func (r *repeat) add(p part, len int, flat int, worked int, loop bool) {
+	r.parts = append(r.parts, p)
+	r.starts = append(r.starts, r.len)
+	r.before = append(r.before, r.worked)
+	r.len += len
+	r.flat += flat
+	r.worked += worked
+	r.hasLoop = r.hasLoop || loop
+}
+
+func (r *repeat) addStep(step int, lc *LineContainer) {
+	flat, worked := 0, 0
+	if lc.construction == FLAT && !lc.stitches().castsOn() {
+		flat = 1
+	}
+	if !lc.stitches().setsUp() {
+		worked = 1
+	}
+	r.add(part{step: step}, 1, flat, worked, false)
+}
+
+func (r *repeat) addRepeat(inner *repeat) {
+	r.add(part{repeat: inner}, inner.len*inner.times, inner.flat*inner.times, inner.worked*inner.times,
+		inner.loop || inner.hasLoop)
+}
+
+// Rows worked before the state the frame is at, in passes before this one and
+// in this one
+func (f frame) rowsBefore() int {
+	return f.iter*f.repeat.worked + f.repeat.before[f.part]
+}
+
+// ------------------ Cursor ------------------
+
+// How far through a repeat, `base` is the state its current pass started at
+type frame struct {
+	repeat *repeat
+	iter   int
+	part   int
+	base   int
+}
+
+// A state of the program as the repeats it's in, outermost first
+type cursor struct {
+	idx    int
+	frames []frame
+}
+
+func (c *cursor) step() int {
+	f := c.frames[len(c.frames)-1]
+	return f.repeat.parts[f.part].step
+}
+
+// Into the first state of any repeat the cursor is at
+func (c *cursor) descend() {
+	for {
+		f := c.frames[len(c.frames)-1]
+		p := f.repeat.parts[f.part]
+		if p.repeat == nil {
+			return
+		}
+		c.frames = append(c.frames, frame{repeat: p.repeat, base: c.idx})
+	}
+}
+
+// On to the state after, false at the end of the program
+func (c *cursor) next() bool {
+	c.idx++
+	for len(c.frames) > 0 {
+		f := &c.frames[len(c.frames)-1]
+		if f.part++; f.part < len(f.repeat.parts) {
+			c.descend()
+			return true
+		}
+		if f.iter++; f.iter < f.repeat.times {
+			f.part, f.base = 0, f.base+f.repeat.len
+			c.descend()
+			return true
+		}
+		c.frames = c.frames[:len(c.frames)-1]
+	}
+	return false
+}
+
+func (e *Engine) begin() (cursor, bool) {
+	if e.Len() == 0 {
+		return cursor{}, false
+	}
+	c := cursor{frames: []frame{{repeat: e.program}}}
+	c.descend()
+	return c, true
+}
+
+// The cursor at `idx`, which must be a state of the program
+func (e *Engine) locate(idx int) cursor {
+	c := cursor{idx: idx}
+	r, base, offset := e.program, 0, idx
+	for {
+		iter := offset / r.len
+		base, offset = base+iter*r.len, offset%r.len
+		i := sort.Search(len(r.starts), func(i int) bool { return r.starts[i] > offset }) - 1
+		c.frames = append(c.frames, frame{repeat: r, iter: iter, part: i, base: base})
+		inner := r.parts[i].repeat
+		if inner == nil {
+			return c
+		}
+		r, base, offset = inner, base+r.starts[i], offset-r.starts[i]
+	}
+}
+
+// The open-ended repeat `idx` is in
+func (e *Engine) loopFrame(idx int) (frame, bool) {
+	if idx < 0 || idx >= e.Len() {
+		return frame{}, false
+	}
+	for _, f := range e.locate(idx).frames {
+		if f.repeat.loop {
+			return f, true
+		}
+	}
+	return frame{}, false
+}
+
+// The last state of every open-ended repeat, in every pass of those around it
+func (e *Engine) loopEnds() []int {
+	ends := make([]int, 0)
+	var find func(r *repeat, base int)
+	find = func(r *repeat, base int) {
+		for i := 0; i < r.times; i++ {
+			for j, p := range r.parts {
+				if p.repeat == nil {
+					continue
+				}
+				start := base + i*r.len + r.starts[j]
+				if p.repeat.loop {
+					ends = append(ends, start+p.repeat.len-1)
+				}
+				if p.repeat.hasLoop {
+					find(p.repeat, start)
+				}
+			}
+		}
+	}
+	if e.program.hasLoop {
+		find(e.program, 0)
+	}
+	return ends
+}
+
+// The first and last states of the open-ended repeat `idx` is in
+func (e *Engine) loopAround(idx int) (int, int, bool) {
+	f, ok := e.loopFrame(idx)
+	if !ok {
+		return 0, 0, false
+	}
+	return f.base, f.base + f.repeat.len - 1, true
+}
+
+// ------------------ Carry ------------------
+
+// What the states worked so far leave for the next, the side a flat row is
+// worked from and the stitches and markers on the needle
+type carry struct {
+	side    Side
+	live    int
+	markers []Marker
+}
+
+func startCarry() carry {
+	return carry{side: RIGHT_SIDE, live: -1}
+}
+
+// Knitting in the round every row is a right side row, knitting flat the work
+// is turned so the sides alternate; rows outside any `flat` or `round` have no
+// side. Stitches are only counted if asked
+func (k *carry) work(s *CurrentState, count bool) error {
+	switch {
+	case s.Lc.construction == NO_CONSTRUCTION:
+	case s.Lc.stitches().castsOn():
+		k.side = RIGHT_SIDE
+	case s.Lc.construction == ROUND:
+		s.Side, s.Round = RIGHT_SIDE, true
+		k.side = RIGHT_SIDE
+	default:
+		s.Side = k.side
+		k.side = k.side.other()
+	}
+
+	var err error
+	if c := s.Lc.stitches(); count && len(s.Lc.Phrases) > 0 {
+		// Turned to work back along the row
+		if s.Side != NO_SIDE && !s.Round && k.live >= 0 {
+			k.markers = mirrorMarkers(k.markers, k.live)
+		}
+		if c.usesMarkers() || len(k.markers) > 0 {
+			k.live, k.markers, err = c.applyWithMarkers(k.live, k.markers)
+		} else {
+			k.live, err = c.apply(k.live)
+		}
+	}
+	s.Stitches, s.Markers = k.live, k.markers
+	return err
+}
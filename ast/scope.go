@@ -0,0 +1,289 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/bodneyc/knit-and-go/lexer"
+)
+
+// ------------------ Scope ------------------
+
+// Declarations are added as the walk for lines reaches them, so a name is only
+// visible after its declaration
+type Scope struct {
+	parent   *Scope
+	node     Node
+	aliases  map[string]*AliasStmt
+	assigns  map[string]*AssignStmt
+	stitches map[string]StitchDef
+	// The name of the stitch defined with each `symbol`
+	symbols map[string]string
+	numbers map[string]int
+	colours map[string]string
+	gauge   *GaugeStmt
+	// `flat` or `round`, if given in this scope
+	construction Construction
+}
+
+func newScope(parent *Scope, node Node) *Scope {
+	return &Scope{
+		parent:   parent,
+		node:     node,
+		aliases:  make(map[string]*AliasStmt),
+		assigns:  make(map[string]*AssignStmt),
+		stitches: make(map[string]StitchDef),
+		symbols:  make(map[string]string),
+		numbers:  make(map[string]int),
+		colours:  make(map[string]string),
+	}
+}
+
+func (s *Scope) lookupAlias(name string) (*AliasStmt, bool) {
+	for ; s != nil; s = s.parent {
+		if alias, ok := s.aliases[name]; ok {
+			return alias, true
+		}
+		// An inner assignment or number shadows an outer alias
+		if _, ok := s.assigns[name]; ok {
+			return nil, false
+		}
+		if _, ok := s.numbers[name]; ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+func (s *Scope) lookupAssign(name string) (*AssignStmt, bool) {
+	for ; s != nil; s = s.parent {
+		if assign, ok := s.assigns[name]; ok {
+			return assign, true
+		}
+		if _, ok := s.aliases[name]; ok {
+			return nil, false
+		}
+		if _, ok := s.numbers[name]; ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+func (s *Scope) lookupNumber(name string) (int, bool) {
+	for ; s != nil; s = s.parent {
+		if n, ok := s.numbers[name]; ok {
+			return n, true
+		}
+		if _, ok := s.aliases[name]; ok {
+			return 0, false
+		}
+		if _, ok := s.assigns[name]; ok {
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+func (s *Scope) lookupStitch(name string) (StitchDef, bool) {
+	for ; s != nil; s = s.parent {
+		if def, ok := s.stitches[name]; ok {
+			return def, true
+		}
+	}
+	return StitchDef{}, false
+}
+
+func (s *Scope) lookupSymbol(symbol string) (string, bool) {
+	for ; s != nil; s = s.parent {
+		if name, ok := s.symbols[symbol]; ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (s *Scope) declareStitch(stmt *StitchStmt) {
+	s.stitches[stmt.Name.Name] = stmt.Def
+	if stmt.Def.Symbol != "" {
+		s.symbols[stmt.Def.Symbol] = stmt.Name.Name
+	}
+}
+
+// ------------------ Parser scopes ------------------
+
+// The parser keeps the stitches defined so far for charts to find, scoped
+// the same as the walk's
+func NewScope(parent *Scope) *Scope {
+	return newScope(parent, nil)
+}
+
+func (s *Scope) Parent() *Scope {
+	return s.parent
+}
+
+func (s *Scope) DeclareStitch(stmt *StitchStmt) {
+	s.declareStitch(stmt)
+}
+
+// A stitch definition visible in the scope, or a builtin, by name
+func (s *Scope) StitchDef(name string) (StitchDef, bool) {
+	if def, ok := s.lookupStitch(name); ok {
+		return def, true
+	}
+	def, ok := builtinStitches[strings.ToLower(name)]
+	return def, ok
+}
+
+// The stitch defined with the `symbol`, in the scope or one around it
+func (s *Scope) StitchBySymbol(symbol string) (string, bool) {
+	return s.lookupSymbol(symbol)
+}
+
+// ------------------ EngineData scopes ------------------
+
+func (e *EngineData) pushScope(node Node) {
+	e.scope = newScope(e.scope, node)
+}
+
+func (e *EngineData) popScope() {
+	e.scope = e.scope.parent
+}
+
+// Every alias followed from a name, in order, stopping short of going round
+// again
+func (e *EngineData) aliasChain(o IdentExpr) []*AliasStmt {
+	chain := make([]*AliasStmt, 0)
+	seen := make(map[string]bool)
+	for name := o.Name; !seen[name]; {
+		seen[name] = true
+		alias, ok := e.scope.lookupAlias(name)
+		if !ok {
+			break
+		}
+		chain = append(chain, alias)
+		name = alias.Rhs.Name
+	}
+	return chain
+}
+
+// A cycle can only be closed by the alias that's just been declared
+func (e *EngineData) checkAliasCycle(s *AliasStmt) error {
+	chain := e.aliasChain(s.Lhs)
+	if len(chain) == 0 || chain[len(chain)-1].Rhs.Name != s.Lhs.Name {
+		return nil
+	}
+	links := make([]string, len(chain))
+	for i, alias := range chain {
+		links[i] = fmt.Sprintf("%s := %s at %s", alias.Lhs.Name, alias.Rhs.Name, alias.Pos().Str())
+	}
+	return ErrorAt(s.Pos(), "", "Alias %s refers back to itself, %s",
+		s.Lhs.Name, strings.Join(links, ", "))
+}
+
+// An alias of a yarn is that yarn too
+func (e *EngineData) declareAlias(s *AliasStmt) error {
+	e.scope.aliases[s.Lhs.Name] = s
+	delete(e.scope.assigns, s.Lhs.Name)
+	delete(e.scope.numbers, s.Lhs.Name)
+	delete(e.scope.colours, s.Lhs.Name)
+	if err := e.checkAliasCycle(s); err != nil {
+		return err
+	}
+	hex, ok := s.colour()
+	if !ok {
+		hex, ok = e.scope.lookupColour(s.Rhs.Name)
+	}
+	if ok {
+		e.scope.colours[s.Lhs.Name] = hex
+		if e.mainYarn.name == "" {
+			e.mainYarn = yarn{name: s.Lhs.Name, hex: hex}
+		}
+	}
+	return nil
+}
+
+// The rhs is walked in the scope of the declaration, not the call
+func (e *EngineData) declareAssign(s *AssignStmt) {
+	e.scope.assigns[s.Lhs.Name] = s
+	delete(e.scope.aliases, s.Lhs.Name)
+	delete(e.scope.numbers, s.Lhs.Name)
+	delete(e.scope.colours, s.Lhs.Name)
+	e.closures[s] = e.scope
+}
+
+func (e *EngineData) declareNumber(lhs IdentExpr, n int) {
+	e.scope.numbers[lhs.Name] = n
+	delete(e.scope.aliases, lhs.Name)
+	delete(e.scope.assigns, lhs.Name)
+	delete(e.scope.colours, lhs.Name)
+}
+
+// Stitch definitions sit alongside aliases, an alias names a stitch while its
+// definition says what it does
+func (e *EngineData) declareStitch(s *StitchStmt) {
+	e.scope.declareStitch(s)
+}
+
+// Static declarations, used only to tell an undeclared name from one used too
+// early
+func (e *EngineData) pushOwner(node Node) {
+	e.owners = append(e.owners, node)
+	if _, ok := e.decls[node]; !ok {
+		e.decls[node] = make(map[string]Position)
+	}
+}
+
+func (e *EngineData) popOwner() {
+	e.owners = e.owners[:len(e.owners)-1]
+}
+
+func (e *EngineData) declareLocal(ident IdentExpr) {
+	if len(e.owners) == 0 {
+		return
+	}
+	decls := e.decls[e.owners[len(e.owners)-1]]
+	if _, ok := decls[ident.Name]; !ok {
+		decls[ident.Name] = ident.Pos()
+	}
+}
+
+func (e *EngineData) checkDeclared(o IdentExpr) error {
+	if o.Name == "" || e.checkParams(o) != nil {
+		return nil
+	}
+	for s := e.scope; s != nil; s = s.parent {
+		if _, ok := s.aliases[o.Name]; ok {
+			return nil
+		}
+		if _, ok := s.assigns[o.Name]; ok {
+			return nil
+		}
+		if _, ok := s.stitches[o.Name]; ok {
+			return nil
+		}
+		if _, ok := s.numbers[o.Name]; ok {
+			return nil
+		}
+		if at, ok := e.decls[s.node][o.Name]; ok {
+			return ErrorAt(o.Pos(), "", "%s used before its declaration at %s", o.Name, at.Str())
+		}
+	}
+	return nil
+}
+
+func (e *EngineData) checkArgsDeclared(args Brackets) error {
+	for _, arg := range args.Args {
+		var err error
+		switch arg.(type) {
+		case *IdentExpr:
+			err = e.checkDeclared(*arg.(*IdentExpr))
+		case *SizeExpr:
+			err = e.checkDeclared(arg.(*SizeExpr).Id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/bodneyc/knit-and-go/lexer"
+	log "github.com/sirupsen/logrus"
+)
+
+// ------------------ Sizes ------------------
+
+// Must be called before the walk for lines, an empty size means the first
+// size the pattern declares
+func (e *EngineData) SelectSize(size string) {
+	e.size = size
+}
+
+func (e *EngineData) sizeNames() string {
+	return strings.Join(e.sizes, ", ")
+}
+
+func (e *EngineData) declareSizes(s *SizesStmt) error {
+	if e.sizes != nil {
+		return ErrorAt(s.Pos(), "", "Sizes declared more than once")
+	}
+	e.sizes = make([]string, len(s.Names))
+	for i, name := range s.Names {
+		e.sizes[i] = name.Name
+	}
+
+	if e.size == "" {
+		e.sizeIdx = 0
+		log.WithField("size", e.sizes[0]).Info("No size given, using the first")
+		return nil
+	}
+	for i, name := range e.sizes {
+		if strings.EqualFold(name, e.size) {
+			e.sizeIdx = i
+			log.WithField("size", name).Info("Using size")
+			return nil
+		}
+	}
+	return ErrorAt(s.Pos(), "", "Unknown size %s, the pattern has: %s", e.size, e.sizeNames())
+}
+
+// Once the walk for lines is done, a size asked for of a pattern without any
+// is a mistake rather than something to ignore
+func (e *EngineData) CheckSize() error {
+	if e.size != "" && e.sizes == nil {
+		return ErrorAt(NO_POS, "", "Size %s given but the pattern doesn't declare any sizes", e.size)
+	}
+	return nil
+}
+
+// The same sizes imported again, into another scope, aren't declared twice
+func (e *EngineData) importSizes(s *SizesStmt) error {
+	if len(e.sizes) == len(s.Names) {
+		same := true
+		for i, name := range s.Names {
+			same = same && e.sizes[i] == name.Name
+		}
+		if same {
+			return nil
+		}
+	}
+	return s.WalkForLines(e)
+}
+
+// Values for each size given before, or without, `sizes`
+const SIZES_HINT = "declare the sizes before giving a value for each, e.g. sizes S M L"
+
+// A division of plain numbers that doesn't work out may be meant as a value
+// for each size
+func (e *EngineData) divideHint(o *ArithExpr) string {
+	if len(e.sizes) == 0 || !isPlainNumber(o.X) || !isPlainNumber(o.Y) {
+		return ""
+	}
+	return fmt.Sprintf("for a value for each of %s separate them with |", e.sizeNames())
+}
+
+func isPlainNumber(x Expr) bool {
+	size, ok := x.(*SizeExpr)
+	return ok && size.Unit == NOUNIT && !size.Before && size.Ni >= 0
+}
+
+func (e *EngineData) checkVariants(args Brackets) error {
+	for _, arg := range args.Args {
+		variant, ok := arg.(*VariantExpr)
+		if !ok {
+			continue
+		}
+		if e.sizes == nil {
+			return ErrorAt(variant.Pos(), SIZES_HINT, "Value for each size given but no sizes declared")
+		}
+		if len(variant.Values) != len(e.sizes) {
+			return ErrorAt(variant.Pos(), "", "%d values given for %d sizes (%s)",
+				len(variant.Values), len(e.sizes), e.sizeNames())
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package ast
 
 import (
 	"fmt"
+	"strings"
 
 	. "github.com/bodneyc/knit-and-go/lexer"
 	"github.com/bodneyc/knit-and-go/util"
@@ -10,9 +11,12 @@ import (
 // ------------------ AliasStmt ----------------
 
 type AliasStmt struct {
-	Lhs  IdentExpr        `json:"lhs"`
-	Rhs  IdentExpr        `json:"rhs"`
-	Desc CommentGroupExpr `json:"desc"`
+	Lhs IdentExpr `json:"lhs"`
+	Rhs IdentExpr `json:"rhs"`
+	// Hex of a yarn, `MC := navy #1b2a4a`, a yarn can also be named for a
+	// colour alone, `CC1 := cream`
+	Colour string           `json:"colour"`
+	Desc   CommentGroupExpr `json:"desc"`
 }
 
 func NewAliasStmt(desc CommentGroupExpr, lhs IdentExpr, rhs IdentExpr) *AliasStmt {
@@ -26,40 +30,175 @@ func NewAliasStmt(desc CommentGroupExpr, lhs IdentExpr, rhs IdentExpr) *AliasStm
 func (s *AliasStmt) stmtNode()     {}
 func (s *AliasStmt) Pos() Position { return s.Lhs.Pos() }
 
-func (s *AliasStmt) WalkForLines(e *EngineData) error { return nil }
+func (s *AliasStmt) WalkForLines(e *EngineData) error {
+	if err := e.declareAlias(s); err != nil {
+		return fmt.Errorf("%w%s", err, util.StackLine())
+	}
+	return nil
+}
+
 func (s *AliasStmt) WalkForLocals(e *EngineData) {
-	e.aliases[s.Lhs.Name] = s.Rhs
+	e.declareLocal(s.Lhs)
 }
 
 // ----------------- AssignStmt ----------------
 
 type AssignStmt struct {
-	Lhs  IdentExpr        `json:"lhs"`
-	Rhs  Expr             `json:"rhs"`
-	Desc CommentGroupExpr `json:"desc"`
+	Lhs    IdentExpr   `json:"lhs"`
+	Params []IdentExpr `json:"params"`
+	Rhs    Expr        `json:"rhs"`
+	// The lines of a chart as they were written, the rhs is worked out from
+	// them
+	Chart []string         `json:"chart"`
+	Desc  CommentGroupExpr `json:"desc"`
+	// How deep it may call itself, `spiral(n, depth=3) = ...`, 0 leaves it to
+	// `--recursion-depth`
+	Depth int `json:"depth,omitempty"`
 }
 
 func (s *AssignStmt) stmtNode()     {}
 func (s *AssignStmt) Pos() Position { return s.Lhs.Pos() }
 
-func (s *AssignStmt) WalkForLines(e *EngineData) error { return nil }
+func (s *AssignStmt) WalkForLines(e *EngineData) error {
+	e.declareAssign(s)
+	return nil
+}
+
 func (s *AssignStmt) WalkForLocals(e *EngineData) {
-	e.assigns[s.Lhs.Name] = &s.Rhs
+	e.declareLocal(s.Lhs)
 	s.Rhs.WalkForLocals(e)
 }
 
-func NewAssignStmt(desc CommentGroupExpr, ident IdentExpr, expr Expr) *AssignStmt {
+// Args of the call are substituted for the parameters while walking the rhs, any
+// arg which is itself a parameter of the caller is resolved first
+func (s *AssignStmt) bindParams(e *EngineData, at Position, args []Expr) (map[string]Expr, error) {
+	if len(args) != len(s.Params) {
+		return nil, ErrorAt(at, "", "%s expects %d argument(s), got %d",
+			s.Lhs.Name, len(s.Params), len(args))
+	}
+	frame := make(map[string]Expr)
+	for i, param := range s.Params {
+		frame[param.Name] = e.substituteParam(args[i])
+	}
+	return frame, nil
+}
+
+func (s *AssignStmt) walkCall(e *EngineData, lc *LineContainer, at Position, args Brackets) error {
+	depth := args.Depth
+	if walk, err := e.checkRecursion(s, at, depth); err != nil || !walk {
+		return err
+	}
+	// The lines of a group are a repeat named for the assignment, any args of
+	// a call without parameters are how many times, `lace(8)`
+	if _, ok := s.Rhs.(*GroupExpr); ok {
+		start := MakeLineContainer()
+		start.Desc = s.Desc.TextSlice(e)
+		if len(s.Params) == 0 {
+			start.Args = args.TextSlice(e)
+			start.approx = e.measureRows(args)
+			start.until = e.isOpenEnded(args)
+			args = MakeBrackets()
+		}
+		e.Instrs = append(e.Instrs, Instr{Kind: START_GROUP_IK, Lc: start, Name: s.Lhs.Name})
+		defer e.emit(END_GROUP_IK, LineContainer{})
+	}
+	if len(s.Params) == 0 {
+		// Without parameters, call args are repeats of the rhs
+		args.WalkForLines(e, lc)
+		return s.walkRhs(e, lc, at, depth)
+	}
+	frame, err := s.bindParams(e, at, args.Args)
+	if err != nil {
+		return err
+	}
+	e.params = append(e.params, frame)
+	defer func() { e.params = e.params[:len(e.params)-1] }()
+	return s.walkRhs(e, lc, at, depth)
+}
+
+func (s *AssignStmt) walkRhs(e *EngineData, lc *LineContainer, at Position, depth int) error {
+	callerScope := e.scope
+	if scope, ok := e.closures[s]; ok {
+		e.scope = scope
+	}
+	e.callers = append(e.callers, call{caller: callerScope, closure: e.scope, assign: s, at: at, depth: depth})
+	defer func() {
+		e.scope = callerScope
+		e.callers = e.callers[:len(e.callers)-1]
+	}()
+	return s.Rhs.WalkForLines(e, lc)
+}
+
+// Must be called before the walk for lines, a call deeper than `depth` into
+// an assignment it's already in is left out, that's where the recursion stops;
+// only for assignments and calls that don't give a depth of their own
+func (e *EngineData) AllowRecursion(depth int) {
+	e.maxDepth = depth
+}
+
+// Whether a call should be walked, it's recursion if the assignment is already
+// being walked further up. The depth is that given by the outermost call of
+// the assignment that gives one, then this call, then the assignment
+func (e *EngineData) checkRecursion(s *AssignStmt, at Position, given int) (bool, error) {
+	depth, first, max := 0, -1, 0
+	for i, c := range e.callers {
+		if c.assign == s {
+			if first < 0 {
+				first = i
+			}
+			if max == 0 {
+				max = c.depth
+			}
+			depth++
+		}
+	}
+	if depth == 0 {
+		return true, nil
+	}
+	if max == 0 {
+		max = given
+	}
+	if max == 0 {
+		max = s.Depth
+	}
+	if max == 0 {
+		max = e.maxDepth
+	}
+	if max == 0 {
+		links := make([]string, 0, len(e.callers)-first+1)
+		for _, c := range e.callers[first:] {
+			links = append(links, fmt.Sprintf("%s at %s", c.assign.Lhs.Name, c.at.Str()))
+		}
+		links = append(links, fmt.Sprintf("%s at %s", s.Lhs.Name, at.Str()))
+		return false, ErrorAt(at, "", "%s is called within itself, %s",
+			s.Lhs.Name, strings.Join(links, " -> "))
+	}
+	return depth <= max, nil
+}
+
+// Only a call of an assignment can go any deeper
+func checkDepth(args Brackets, at Position, what string) error {
+	if args.Depth == 0 {
+		return nil
+	}
+	return ErrorAt(at, "", "A depth is given to a call of an assignment, not %s", what)
+}
+
+func NewAssignStmt(desc CommentGroupExpr, ident IdentExpr, params []IdentExpr, expr Expr) *AssignStmt {
 	return &AssignStmt{
-		Lhs:  ident,
-		Rhs:  expr,
-		Desc: desc,
+		Lhs:    ident,
+		Params: params,
+		Rhs:    expr,
+		Desc:   desc,
 	}
 }
 
 // ------------------ RowStmt ------------------
 
 type RowStmt struct {
-	Row  RowExpr          `json:"row"`
+	Row RowExpr `json:"row"`
+	// Only given to the rows of a chart
+	Side Side             `json:"side"`
 	Desc CommentGroupExpr `json:"desc"`
 }
 
@@ -74,18 +213,28 @@ func (s *RowStmt) stmtNode()     {}
 func (s *RowStmt) Pos() Position { return s.Row.Stitches[0].Pos() }
 
 func (s *RowStmt) WalkForLines(e *EngineData) error {
-	startLc, endLc := START_OF_ROW_LC, END_OF_ROW_LC
+	if err := checkDepth(s.Row.Args, s.Pos(), "a row"); err != nil {
+		return err
+	}
+	startLc := MakeLineContainer()
 	startLc.Desc = s.Desc.TextSlice(e)
 	startLc.Args = s.Row.Args.TextSlice(e)
-	e.Lines = append(e.Lines, startLc)
+	startLc.approx = e.measureRows(s.Row.Args)
+	startLc.until = e.isOpenEnded(s.Row.Args)
+	e.emit(START_ROW_IK, startLc)
 	lc := MakeLineContainer()
+	lc.at, lc.construction, lc.side = s.Pos(), e.construction(), s.Side
 	if err := s.Row.WalkForLines(e, &lc); err != nil {
 		return fmt.Errorf("%w%s", err, util.StackLine())
 	}
-	if !lc.isEmpty() {
-		e.Lines = append(e.Lines, lc)
+	// Numbered repeats of a whole row are separate states
+	if lc.Across = e.countFrom(s.Row.Args, true); lc.Across.Kind == FIXED_CK {
+		lc.Across.N = 1
 	}
-	e.Lines = append(e.Lines, endLc)
+	if len(lc.Phrases) > 0 {
+		e.emit(ROW_IK, lc)
+	}
+	e.emit(END_ROW_IK, LineContainer{})
 	return nil
 }
 
@@ -111,12 +260,25 @@ func (s *GroupStmt) stmtNode()     {}
 func (s *GroupStmt) Pos() Position { return s.Group.LBrace }
 
 func (s *GroupStmt) WalkForLines(e *EngineData) error {
-	lc := START_OF_GROUP_LC
+	if err := checkDepth(s.Group.Args, s.Pos(), "a group"); err != nil {
+		return err
+	}
+	if err := e.checkVariants(s.Group.Args); err != nil {
+		return err
+	}
+	if err := e.checkNumbers(s.Group.Args); err != nil {
+		return err
+	}
+	lc := MakeLineContainer()
 	lc.Desc = s.Desc.TextSlice(e)
 	lc.Args = s.Group.Args.TextSlice(e)
-	e.Lines = append(e.Lines, lc)
-	s.Group.WalkForLines(e, &lc)
-	e.Lines = append(e.Lines, END_OF_GROUP_LC)
+	lc.approx = e.measureRows(s.Group.Args)
+	lc.until = e.isOpenEnded(s.Group.Args)
+	e.emit(START_GROUP_IK, lc)
+	if err := s.Group.WalkForLines(e, &lc); err != nil {
+		return fmt.Errorf("%w%s", err, util.StackLine())
+	}
+	e.emit(END_GROUP_IK, LineContainer{})
 	return nil
 }
 
@@ -146,33 +308,136 @@ func (s *BlockStmt) stmtNode()     {}
 func (s *BlockStmt) Pos() Position { return s.Start }
 
 func (s *BlockStmt) WalkForLines(e *EngineData) error {
-	lc := START_OF_BLOCK_LC
+	e.pushScope(s)
+	defer e.popScope()
+	lc := MakeLineContainer()
 	lc.Desc = s.Desc.TextSlice(e)
-	e.Lines = append(e.Lines, lc)
+	e.emit(START_BLOCK_IK, lc)
 	for _, subblock := range s.Block {
-		subblock.WalkForLines(e)
+		if err := subblock.WalkForLines(e); err != nil {
+			return fmt.Errorf("%w%s", err, util.StackLine())
+		}
 	}
-	e.Lines = append(e.Lines, END_OF_BLOCK_LC)
+	e.emit(END_BLOCK_IK, LineContainer{})
 	return nil
 }
 
 func (s *BlockStmt) WalkForLocals(e *EngineData) {
+	e.pushOwner(s)
+	defer e.popOwner()
 	for _, subblock := range s.Block {
 		subblock.WalkForLocals(e)
 	}
 }
 
-// Fuck... to do, to do big time
+// ----------------- StitchStmt ----------------
+
+type StitchStmt struct {
+	Name   IdentExpr        `json:"name"`
+	Def    StitchDef        `json:"def"`
+	RBrace Position         `json:"rbrace"`
+	Desc   CommentGroupExpr `json:"desc"`
+}
+
+func NewStitchStmt(desc CommentGroupExpr, name IdentExpr, def StitchDef) *StitchStmt {
+	return &StitchStmt{
+		Name: name,
+		Def:  def,
+		Desc: desc,
+	}
+}
+
+func (s *StitchStmt) stmtNode()     {}
+func (s *StitchStmt) Pos() Position { return s.Name.Pos() }
+
+func (s *StitchStmt) WalkForLines(e *EngineData) error {
+	e.declareStitch(s)
+	return nil
+}
+
+func (s *StitchStmt) WalkForLocals(e *EngineData) {
+	e.declareLocal(s.Name)
+}
+
+// ----------------- SizesStmt -----------------
+
+type SizesStmt struct {
+	At    Position         `json:"at"`
+	Names []IdentExpr      `json:"names"`
+	Desc  CommentGroupExpr `json:"desc"`
+}
+
+func NewSizesStmt(desc CommentGroupExpr, at Position, names []IdentExpr) *SizesStmt {
+	return &SizesStmt{
+		At:    at,
+		Names: names,
+		Desc:  desc,
+	}
+}
+
+func (s *SizesStmt) stmtNode()     {}
+func (s *SizesStmt) Pos() Position { return s.At }
+
+func (s *SizesStmt) WalkForLines(e *EngineData) error {
+	if err := e.declareSizes(s); err != nil {
+		return fmt.Errorf("%w%s", err, util.StackLine())
+	}
+	return nil
+}
+
+func (s *SizesStmt) WalkForLocals(e *EngineData) {}
+
+// ----------------- ImportStmt ----------------
 
 type ImportStmt struct {
-	Doc         *CommentGroupExpr `json:"doc"`
-	Name        *IdentExpr        `json:"name"`
-	CommentExpr *CommentGroupExpr `json:"comment"`
+	At    Position         `json:"at"`
+	Path  string           `json:"path"`
+	File  string           `json:"file"`
+	Block *BlockStmt       `json:"block"`
+	Desc  CommentGroupExpr `json:"desc"`
+}
+
+func NewImportStmt(desc CommentGroupExpr, at Position, path string) *ImportStmt {
+	return &ImportStmt{
+		At:    at,
+		Path:  path,
+		File:  "",
+		Block: nil,
+		Desc:  desc,
+	}
 }
 
 func (s *ImportStmt) stmtNode()     {}
-func (s *ImportStmt) Pos() Position { return s.Name.Pos() }
+func (s *ImportStmt) Pos() Position { return s.At }
+
+// Imports only ever contribute locals, declared in the scope of the import,
+// and the sizes of the pattern; rows in the imported file are ignored
+func (s *ImportStmt) WalkForLines(e *EngineData) error {
+	if s.Block == nil {
+		return nil
+	}
+	for _, stmt := range s.Block.Block {
+		switch stmt := stmt.(type) {
+		case *SizesStmt:
+			if err := e.importSizes(stmt); err != nil {
+				return fmt.Errorf("%w%s", err, util.StackLine())
+			}
+		case *AliasStmt, *AssignStmt, *StitchStmt, *NumberStmt, *GaugeStmt, *ImportStmt:
+			if err := stmt.WalkForLines(e); err != nil {
+				return fmt.Errorf("%w%s", err, util.StackLine())
+			}
+		}
+	}
+	return nil
+}
 
+// `Block` is nil if the file was already included in the scope or one around
+// it
 func (s *ImportStmt) WalkForLocals(e *EngineData) {
-	// TODO:
+	if s.Block == nil {
+		return
+	}
+	for _, stmt := range s.Block.Block {
+		stmt.WalkForLocals(e)
+	}
 }
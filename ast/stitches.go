@@ -0,0 +1,414 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/bodneyc/knit-and-go/lexer"
+)
+
+// ------------------ StitchDef ------------------
+
+type Lean string
+
+const (
+	NO_LEAN    Lean = ""
+	LEFT_LEAN  Lean = "left"
+	RIGHT_LEAN Lean = "right"
+)
+
+// What a stitch does to a marker, given one, `sl(m)`, or working no stitches,
+// `pm`; anything else given a marker works up to it
+type MarkerRole string
+
+const (
+	NO_MARKER     MarkerRole = ""
+	PLACE_MARKER  MarkerRole = "place"
+	SLIP_MARKER   MarkerRole = "slip"
+	REMOVE_MARKER MarkerRole = "remove"
+)
+
+// Stitches taken off the left needle and put on the right, per stitch worked,
+// with how it should look on a chart. A setup instruction, `use(5mm)` or
+// `co(10)`, gets things ready rather than working a row
+type StitchDef struct {
+	Consumes int        `json:"consumes"`
+	Produces int        `json:"produces"`
+	Lean     Lean       `json:"lean"`
+	Symbol   string     `json:"symbol"`
+	Marker   MarkerRole `json:"marker"`
+	Setup    bool       `json:"setup,omitempty"`
+}
+
+func counts(consumes int, produces int) StitchDef {
+	return StitchDef{Consumes: consumes, Produces: produces}
+}
+
+func setup() StitchDef {
+	return StitchDef{Setup: true}
+}
+
+func (d StitchDef) asSetup() StitchDef {
+	d.Setup = true
+	return d
+}
+
+// Setup that puts nothing on the needle, `use(5mm)`, works no stitches
+// whatever it's given
+func (d StitchDef) worksNothing() bool {
+	return d.Setup && d.Consumes == 0 && d.Produces == 0
+}
+
+func (d StitchDef) withMarker(role MarkerRole) StitchDef {
+	d.Marker = role
+	return d
+}
+
+var builtinStitches = map[string]StitchDef{
+	"k": counts(1, 1), "knit": counts(1, 1),
+	"p": counts(1, 1), "purl": counts(1, 1),
+	"sl": counts(1, 1).withMarker(SLIP_MARKER), "slip": counts(1, 1).withMarker(SLIP_MARKER),
+	"ktbl": counts(1, 1), "ptbl": counts(1, 1),
+
+	"k2t": counts(2, 1), "k2tog": counts(2, 1), "knit-two-tog": counts(2, 1), "knit-two-together": counts(2, 1),
+	"p2t": counts(2, 1), "p2tog": counts(2, 1), "purl-two-tog": counts(2, 1), "purl-two-together": counts(2, 1),
+	"ssk": counts(2, 1), "ssp": counts(2, 1), "skp": counts(2, 1),
+	"k3t": counts(3, 1), "k3tog": counts(3, 1), "p3tog": counts(3, 1),
+	"sk2p": counts(3, 1), "s2kp": counts(3, 1), "cdd": counts(3, 1),
+
+	"yo": counts(0, 1), "yarn-over": counts(0, 1),
+	"m1": counts(0, 1), "m1l": counts(0, 1), "m1r": counts(0, 1), "make-one": counts(0, 1),
+	"kfb": counts(1, 2), "pfb": counts(1, 2),
+	"knit-front-and-back": counts(1, 2), "knit-forward-and-back": counts(1, 2),
+
+	"co": counts(0, 1).asSetup(), "cast-on": counts(0, 1).asSetup(),
+	"bo": counts(1, 0), "bind-off": counts(1, 0),
+	"cof": counts(1, 0), "cast-off": counts(1, 0),
+
+	"hold": counts(1, 0), "put-on-holder": counts(1, 0), "place-on-holder": counts(1, 0),
+
+	"pm": counts(0, 0).withMarker(PLACE_MARKER).asSetup(), "place-marker": counts(0, 0).withMarker(PLACE_MARKER).asSetup(),
+	"sm": counts(0, 0).withMarker(SLIP_MARKER), "slip-marker": counts(0, 0).withMarker(SLIP_MARKER),
+	"rm": counts(0, 0).withMarker(REMOVE_MARKER), "remove-marker": counts(0, 0).withMarker(REMOVE_MARKER),
+
+	"use": setup(), "needle-selection": setup(),
+}
+
+// What's worked on the wrong side to look the same from the right side
+var wrongSideStitches = map[string]string{
+	"k": "p", "knit": "purl", "p": "k", "purl": "knit",
+	"ktbl": "ptbl", "ptbl": "ktbl",
+	"k2t": "p2t", "k2tog": "p2tog", "p2t": "k2t", "p2tog": "k2tog",
+	"ssk": "ssp", "ssp": "ssk",
+	"k3tog": "p3tog", "p3tog": "k3tog",
+}
+
+// A builtin's wrong side counterpart, anything else is worked as it is
+func WrongSideStitch(name string) string {
+	if ws, ok := wrongSideStitches[strings.ToLower(name)]; ok {
+		return ws
+	}
+	return name
+}
+
+// Definitions in the pattern before the builtins, each for what the name is an
+// alias of before the name as written
+func (e *EngineData) lookupStitch(id IdentExpr) (StitchDef, bool) {
+	names := []string{e.checkAliases(id).Name, id.Name}
+	for _, name := range names {
+		if def, ok := e.scope.lookupStitch(name); ok {
+			return def, true
+		}
+	}
+	for _, name := range names {
+		if def, ok := builtinStitches[strings.ToLower(name)]; ok {
+			return def, true
+		}
+	}
+	return StitchDef{}, false
+}
+
+// A stitch definition visible in the scope being walked, or a builtin, by name
+func (e *EngineData) StitchDef(name string) (StitchDef, bool) {
+	return e.scope.StitchDef(name)
+}
+
+// A builtin alone, for when there's no pattern to look in
+func BuiltinStitch(name string) (StitchDef, bool) {
+	def, ok := builtinStitches[strings.ToLower(name)]
+	return def, ok
+}
+
+// Every builtin's name, in no particular order
+func BuiltinStitchNames() []string {
+	names := make([]string, 0, len(builtinStitches))
+	for name := range builtinStitches {
+		names = append(names, name)
+	}
+	return names
+}
+
+// ------------------ Stitch counts ------------------
+
+type CountKind int
+
+const (
+	FIXED_CK CountKind = iota
+	TO_END_CK
+	BEFORE_END_CK
+	// `-m`, up to the next marker
+	UNTIL_MARKER_CK
+	// `m`, the marker itself for a stitch with a marker role
+	MARKER_CK
+	UNKNOWN_CK
+)
+
+// A measurement on a group is a number of rows worked the same way, so as far
+// as the stitches go it's worked once, on a stitch it needs the gauge
+func (e *EngineData) countFrom(args Brackets, group bool) Count {
+	// Quoted text only describes the stitch, a yarn only what it's worked in
+	counted := make([]Expr, 0, len(args.Args))
+	for _, arg := range args.Args {
+		if _, ok := arg.(*StringExpr); ok {
+			continue
+		}
+		if _, ok := e.yarnArg(arg); ok {
+			continue
+		}
+		counted = append(counted, arg)
+	}
+	if len(counted) == 0 {
+		return Count{Kind: FIXED_CK, N: 1}
+	}
+	if len(counted) > 1 {
+		return Count{Kind: UNKNOWN_CK}
+	}
+	arg := e.substituteParam(counted[0])
+	if id, ok := arg.(*IdentExpr); ok && e.isMarker(*id) {
+		return Count{Kind: MARKER_CK, Marker: id.Name}
+	}
+	size, ok := arg.(*SizeExpr)
+	if !ok {
+		return Count{Kind: UNKNOWN_CK}
+	}
+	switch {
+	case size.Unit == NOUNIT && size.Ni < 0 && e.isMarker(size.Id) && size.Before:
+		return Count{Kind: UNTIL_MARKER_CK, Marker: size.Id.Name}
+	case size.Unit == NOUNIT && size.Ni < 0 && e.isMarker(size.Id):
+		return Count{Kind: MARKER_CK, Marker: size.Id.Name}
+	case size.Unit == ASTERISK && !size.Before:
+		return Count{Kind: TO_END_CK, Unit: size.Unit}
+	case size.Unit == NOUNIT && size.Ni >= 0 && size.Before:
+		return Count{Kind: BEFORE_END_CK, N: int(size.Ni)}
+	case size.Unit == NOUNIT && size.Ni >= 0:
+		return Count{Kind: FIXED_CK, N: int(size.Ni)}
+	case size.Unit != NOUNIT && size.Unit != ASTERISK && group:
+		return Count{Kind: FIXED_CK, N: 1, Unit: size.Unit}
+	}
+	if n, ok := e.measure(size, false); ok {
+		return Count{Kind: FIXED_CK, N: n, Unit: size.Unit}
+	}
+	return Count{Kind: UNKNOWN_CK, Unit: size.Unit}
+}
+
+// How many of the stitch are worked, a stitch without a marker role given a
+// marker works up to it
+func (e *EngineData) countStitch(def *StitchDef, args Brackets) Count {
+	count := e.countFrom(args, false)
+	if count.Kind == MARKER_CK && (def == nil || def.Marker == NO_MARKER) {
+		count.Kind = UNTIL_MARKER_CK
+	}
+	return count
+}
+
+// Either a single stitch or, with `children`, a group of them
+type countElem struct {
+	at       Position
+	name     string
+	def      StitchDef
+	known    bool
+	spec     Count
+	yarn     string
+	children []*countElem
+}
+
+// The stitches of the row to be counted, those in braces are a group worked as
+// many times as the closing brace says, and the whole row `Across` times
+func (o *LineContainer) stitches() *countElem {
+	root := &countElem{at: o.at, known: true, spec: o.Across, children: make([]*countElem, 0)}
+	open := []*countElem{root}
+	for _, p := range o.Phrases {
+		top := open[len(open)-1]
+		switch p.Kind {
+		case STITCH_PK:
+			elem := &countElem{at: p.At, name: p.Stitch, spec: p.Count, yarn: p.Yarn}
+			if p.Def != nil {
+				elem.def, elem.known = *p.Def, true
+			}
+			top.children = append(top.children, elem)
+		case OPEN_PK:
+			elem := &countElem{at: p.At, known: true, children: make([]*countElem, 0)}
+			top.children = append(top.children, elem)
+			open = append(open, elem)
+		case CLOSE_PK:
+			top.spec = p.Count
+			open = open[:len(open)-1]
+		}
+	}
+	return root
+}
+
+// One pass of an element as `cons` and `prod` plus, if it has one, `perCons`
+// and `perProd` for each repeat of the single open-ended element in it;
+// `after` is what's consumed after the open element
+type linear struct {
+	cons, prod       int
+	perCons, perProd int
+	open             *countElem
+	after            int
+}
+
+func (c *countElem) linear() (linear, bool) {
+	var l linear
+	if c.children == nil && c.def.worksNothing() {
+		return l, true
+	}
+	if c.spec.Kind == UNKNOWN_CK {
+		return l, false
+	}
+
+	if c.children == nil {
+		if !c.known || c.spec.Kind == UNTIL_MARKER_CK {
+			return l, false
+		}
+		if c.markerRole() != NO_MARKER {
+			return l, true
+		}
+		if c.spec.Kind == FIXED_CK {
+			return linear{cons: c.def.Consumes * c.spec.N, prod: c.def.Produces * c.spec.N}, true
+		}
+		return linear{perCons: c.def.Consumes, perProd: c.def.Produces, open: c}, true
+	}
+
+	for _, child := range c.children {
+		cl, ok := child.linear()
+		if !ok || (cl.open != nil && l.open != nil) {
+			return l, false
+		}
+		if l.open != nil {
+			l.after += cl.cons
+		}
+		if cl.open != nil {
+			l.open, l.perCons, l.perProd, l.after = cl.open, cl.perCons, cl.perProd, cl.after
+		}
+		l.cons += cl.cons
+		l.prod += cl.prod
+	}
+
+	switch c.spec.Kind {
+	case FIXED_CK:
+		if l.open != nil && c.spec.N != 1 {
+			return l, false
+		}
+		l.cons *= c.spec.N
+		l.prod *= c.spec.N
+	case TO_END_CK, BEFORE_END_CK:
+		if l.open != nil {
+			return l, false
+		}
+		l = linear{perCons: l.cons, perProd: l.prod, open: c}
+	}
+	return l, true
+}
+
+// Stitches on the needle after working the row once from `live`, -1 if that
+// can't be known, a count may still be given alongside an error
+func (c *countElem) apply(live int) (int, error) {
+	l, ok := c.linear()
+	if !ok {
+		return -1, nil
+	}
+
+	if live < 0 {
+		// Nothing known on the needle, only a row that works no stitches
+		//  and puts some on (cast-on) tells us anything
+		if l.open == nil && l.cons == 0 && l.prod > 0 {
+			return l.prod, nil
+		}
+		return -1, nil
+	}
+
+	if l.open == nil {
+		if l.cons > live {
+			return -1, fmt.Errorf("%s: Row works %d stitches but only %d are on the needle",
+				c.at.Str(), l.cons, live)
+		}
+		return live - l.cons + l.prod, nil
+	}
+
+	if l.open.spec.Kind == BEFORE_END_CK && l.after != l.open.spec.N {
+		return -1, fmt.Errorf("%s: Repeat stops %d stitches before the end but %d are worked after it",
+			l.open.at.Str(), l.open.spec.N, l.after)
+	}
+
+	avail := live - l.cons
+	if avail < 0 {
+		return -1, fmt.Errorf("%s: Row works %d stitches but only %d are on the needle",
+			c.at.Str(), l.cons, live)
+	}
+	if l.perCons == 0 {
+		return -1, nil
+	}
+	// Any left over are just left on the needle
+	reps := avail / l.perCons
+	live = live - l.cons - reps*l.perCons + l.prod + reps*l.perProd
+	if avail%l.perCons != 0 {
+		return live, fmt.Errorf("%s: Repeat of %d stitches does not divide evenly into the %d available",
+			l.open.at.Str(), l.perCons, avail)
+	}
+	return live, nil
+}
+
+// ------------------ Engine ------------------
+
+// Why the stitches stop being known after a row, from the first stitch or
+// braces in it worked a number of times that can't be counted
+func uncounted(lc *LineContainer) error {
+	for _, p := range lc.Phrases {
+		if p.Kind != STITCH_PK && p.Kind != CLOSE_PK || p.Count.Kind != UNKNOWN_CK {
+			continue
+		}
+		what := p.Stitch
+		if p.Kind == CLOSE_PK {
+			what = "the stitches in braces"
+		}
+		if p.Count.Unit.isLength() {
+			return fmt.Errorf("%s: Stitches can't be counted from here, %s is worked for a length and there's no gauge to count it by",
+				lc.at.Str(), what)
+		}
+		return fmt.Errorf("%s: Stitches can't be counted from here, it isn't known how many of %s are worked",
+			lc.at.Str(), what)
+	}
+	return nil
+}
+
+// Stitches, and any markers, on the needle after each state, errors are unique
+// and in order
+func (e *Engine) CountStitches() []error {
+	errs := make([]error, 0)
+	seen := make(map[string]bool)
+	e.counted, e.marks = true, nil
+	e.keep()
+	known := false
+	e.walk(func(idx int, s *CurrentState, err error) {
+		if err == nil && known && s.Stitches < 0 {
+			err = uncounted(&s.Lc)
+		}
+		known = s.Stitches >= 0
+		if err != nil && !seen[err.Error()] {
+			seen[err.Error()] = true
+			errs = append(errs, err)
+		}
+	})
+	return errs
+}
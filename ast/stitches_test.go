@@ -0,0 +1,38 @@
+package ast_test
+
+import (
+	"strings"
+	"testing"
+)
+
+// Said once, where the count is lost, from the stitch that loses it
+func TestCountStitchesSaysWhyUncounted(t *testing.T) {
+	cases := map[string]string{
+		"co(10)\nk(2cm) k(*)\nk(10)\n":                        "test.knit:2:1: Stitches can't be counted from here, k is worked for a length",
+		"K := knit\nco(10)\nK(2, 3) k(*)\n":                   "test.knit:3:1: Stitches can't be counted from here, it isn't known how many of knit are worked",
+		"gauge(22 sts, 30 rows, 10cm)\nco(10)\nk(2cm) k(*)\n": "",
+		"co(10)\n{ k(2cm) k(*) }(3)\n":                        "test.knit:2:3: Stitches can't be counted from here, k is worked for a length",
+	}
+	for text, want := range cases {
+		errs := compile(t, "test.knit", text).CountStitches()
+		switch {
+		case want == "" && len(errs) > 0:
+			t.Errorf("%q gave %v", text, errs)
+		case want != "" && (len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), want)):
+			t.Errorf("%q gave %v, want one error starting %q", text, errs, want)
+		}
+	}
+}
+
+// Working up to a marker leaves room for what's worked before it's slipped
+func TestWorkUpToMarker(t *testing.T) {
+	text := "m := marker\nco(20)\nk(5) pm k(5) pm k(5) pm k(5) pm\n{ kfb k(-m) kfb sm }(*)\n"
+	e := compile(t, "test.knit", text)
+	if errs := e.CountStitches(); len(errs) > 0 {
+		t.Fatalf("gave %v", errs)
+	}
+	states := walked(e)
+	if got := states[len(states)-1].Stitches; got != 28 {
+		t.Errorf("gave %d stitches, want 28", got)
+	}
+}
@@ -57,6 +57,42 @@ func (o *BlockStmt) UnmarshalJSON(b []byte) error {
 				return fmt.Errorf("%w%s", e, StackLine())
 			}
 			o.Block[i] = &p
+		case "ImportStmt":
+			var p ImportStmt
+			if e := json.Unmarshal(*stmtRaw, &p); e != nil {
+				return fmt.Errorf("%w%s", e, StackLine())
+			}
+			o.Block[i] = &p
+		case "StitchStmt":
+			var p StitchStmt
+			if e := json.Unmarshal(*stmtRaw, &p); e != nil {
+				return fmt.Errorf("%w%s", e, StackLine())
+			}
+			o.Block[i] = &p
+		case "SizesStmt":
+			var p SizesStmt
+			if e := json.Unmarshal(*stmtRaw, &p); e != nil {
+				return fmt.Errorf("%w%s", e, StackLine())
+			}
+			o.Block[i] = &p
+		case "NumberStmt":
+			var p NumberStmt
+			if e := json.Unmarshal(*stmtRaw, &p); e != nil {
+				return fmt.Errorf("%w%s", e, StackLine())
+			}
+			o.Block[i] = &p
+		case "GaugeStmt":
+			var p GaugeStmt
+			if e := json.Unmarshal(*stmtRaw, &p); e != nil {
+				return fmt.Errorf("%w%s", e, StackLine())
+			}
+			o.Block[i] = &p
+		case "ConstructionStmt":
+			var p ConstructionStmt
+			if e := json.Unmarshal(*stmtRaw, &p); e != nil {
+				return fmt.Errorf("%w%s", e, StackLine())
+			}
+			o.Block[i] = &p
 		default:
 			return fmt.Errorf("Unknown type field %s", m["type"])
 		}
@@ -146,6 +182,25 @@ func (o *AssignStmt) UnmarshalJSON(b []byte) error {
 		return fmt.Errorf("%w%s", e, StackLine())
 	}
 
+	o.Params = make([]IdentExpr, 0)
+	if raw, ok := rawMap["params"]; ok && raw != nil {
+		if e := json.Unmarshal(*raw, &o.Params); e != nil {
+			return fmt.Errorf("%w%s", e, StackLine())
+		}
+	}
+
+	if raw, ok := rawMap["chart"]; ok && raw != nil {
+		if e := json.Unmarshal(*raw, &o.Chart); e != nil {
+			return fmt.Errorf("%w%s", e, StackLine())
+		}
+	}
+
+	if raw, ok := rawMap["depth"]; ok && raw != nil {
+		if e := json.Unmarshal(*raw, &o.Depth); e != nil {
+			return fmt.Errorf("%w%s", e, StackLine())
+		}
+	}
+
 	if e := json.Unmarshal(*rawMap["desc"], &o.Desc); e != nil {
 		return fmt.Errorf("%w%s", e, StackLine())
 	}
@@ -252,6 +307,42 @@ func (o *GroupExpr) UnmarshalJSON(b []byte) error {
 				return fmt.Errorf("%w%s", e, StackLine())
 			}
 			o.Lines[i] = &p
+		case "ImportStmt":
+			var p ImportStmt
+			if e := json.Unmarshal(*stmtRaw, &p); e != nil {
+				return fmt.Errorf("%w%s", e, StackLine())
+			}
+			o.Lines[i] = &p
+		case "StitchStmt":
+			var p StitchStmt
+			if e := json.Unmarshal(*stmtRaw, &p); e != nil {
+				return fmt.Errorf("%w%s", e, StackLine())
+			}
+			o.Lines[i] = &p
+		case "SizesStmt":
+			var p SizesStmt
+			if e := json.Unmarshal(*stmtRaw, &p); e != nil {
+				return fmt.Errorf("%w%s", e, StackLine())
+			}
+			o.Lines[i] = &p
+		case "NumberStmt":
+			var p NumberStmt
+			if e := json.Unmarshal(*stmtRaw, &p); e != nil {
+				return fmt.Errorf("%w%s", e, StackLine())
+			}
+			o.Lines[i] = &p
+		case "GaugeStmt":
+			var p GaugeStmt
+			if e := json.Unmarshal(*stmtRaw, &p); e != nil {
+				return fmt.Errorf("%w%s", e, StackLine())
+			}
+			o.Lines[i] = &p
+		case "ConstructionStmt":
+			var p ConstructionStmt
+			if e := json.Unmarshal(*stmtRaw, &p); e != nil {
+				return fmt.Errorf("%w%s", e, StackLine())
+			}
+			o.Lines[i] = &p
 		default:
 			return fmt.Errorf("Unknown type field %s", m["type"])
 		}
@@ -313,6 +404,12 @@ func (o *RowExpr) UnmarshalJSON(b []byte) error {
 				return fmt.Errorf("%w%s", e, StackLine())
 			}
 			o.Stitches[i] = &p
+		case "StringExpr":
+			var p StringExpr
+			if e := json.Unmarshal(*exprRaw, &p); e != nil {
+				return fmt.Errorf("%w%s", e, StackLine())
+			}
+			o.Stitches[i] = &p
 		default:
 			return fmt.Errorf("Invalid assignment rhs: %s", m["type"])
 		}
@@ -327,6 +424,12 @@ func (o *Brackets) UnmarshalJSON(b []byte) error {
 		return fmt.Errorf("%w%s", e, StackLine())
 	}
 
+	if raw, ok := rawMap["depth"]; ok && raw != nil {
+		if e := json.Unmarshal(*raw, &o.Depth); e != nil {
+			return fmt.Errorf("%w%s", e, StackLine())
+		}
+	}
+
 	if val, ok := rawMap["args"]; !ok {
 		return fmt.Errorf("\"args\" does not exist in Brackets")
 	} else {
@@ -379,6 +482,24 @@ func (o *Brackets) UnmarshalJSON(b []byte) error {
 				return fmt.Errorf("%w%s", e, StackLine())
 			}
 			o.Args[i] = &p
+		case "StringExpr":
+			var p StringExpr
+			if e := json.Unmarshal(*exprRaw, &p); e != nil {
+				return fmt.Errorf("%w%s", e, StackLine())
+			}
+			o.Args[i] = &p
+		case "VariantExpr":
+			var p VariantExpr
+			if e := json.Unmarshal(*exprRaw, &p); e != nil {
+				return fmt.Errorf("%w%s", e, StackLine())
+			}
+			o.Args[i] = &p
+		case "ArithExpr":
+			var p ArithExpr
+			if e := json.Unmarshal(*exprRaw, &p); e != nil {
+				return fmt.Errorf("%w%s", e, StackLine())
+			}
+			o.Args[i] = &p
 		default:
 			return fmt.Errorf("Invalid assignment rhs: %s", m["type"])
 		}
@@ -386,3 +507,74 @@ func (o *Brackets) UnmarshalJSON(b []byte) error {
 
 	return nil
 }
+
+// Anything that can be worked out as a number
+func unmarshalNumberExpr(raw *json.RawMessage) (Expr, error) {
+	var m map[string]interface{}
+	if e := json.Unmarshal(*raw, &m); e != nil {
+		return nil, fmt.Errorf("%w%s", e, StackLine())
+	}
+
+	var p Expr
+	switch m["type"] {
+	case "IdentExpr":
+		p = &IdentExpr{}
+	case "SizeExpr":
+		p = &SizeExpr{}
+	case "VariantExpr":
+		p = &VariantExpr{}
+	case "ArithExpr":
+		p = &ArithExpr{}
+	default:
+		return nil, fmt.Errorf("Invalid number: %s", m["type"])
+	}
+	if e := json.Unmarshal(*raw, p); e != nil {
+		return nil, fmt.Errorf("%w%s", e, StackLine())
+	}
+	return p, nil
+}
+
+func (o *ArithExpr) UnmarshalJSON(b []byte) error {
+	var rawMap map[string]*json.RawMessage
+	if e := json.Unmarshal(b, &rawMap); e != nil {
+		return fmt.Errorf("%w%s", e, StackLine())
+	}
+
+	if e := json.Unmarshal(*rawMap["at"], &o.At); e != nil {
+		return fmt.Errorf("%w%s", e, StackLine())
+	}
+	if e := json.Unmarshal(*rawMap["op"], &o.Op); e != nil {
+		return fmt.Errorf("%w%s", e, StackLine())
+	}
+
+	var e error
+	if o.X, e = unmarshalNumberExpr(rawMap["x"]); e != nil {
+		return fmt.Errorf("%w%s", e, StackLine())
+	}
+	if o.Y, e = unmarshalNumberExpr(rawMap["y"]); e != nil {
+		return fmt.Errorf("%w%s", e, StackLine())
+	}
+
+	return nil
+}
+
+func (o *NumberStmt) UnmarshalJSON(b []byte) error {
+	var rawMap map[string]*json.RawMessage
+	if e := json.Unmarshal(b, &rawMap); e != nil {
+		return fmt.Errorf("%w%s", e, StackLine())
+	}
+
+	if e := json.Unmarshal(*rawMap["lhs"], &o.Lhs); e != nil {
+		return fmt.Errorf("%w%s", e, StackLine())
+	}
+	if e := json.Unmarshal(*rawMap["desc"], &o.Desc); e != nil {
+		return fmt.Errorf("%w%s", e, StackLine())
+	}
+
+	var e error
+	if o.Rhs, e = unmarshalNumberExpr(rawMap["rhs"]); e != nil {
+		return fmt.Errorf("%w%s", e, StackLine())
+	}
+
+	return nil
+}
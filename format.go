@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/bodneyc/knit-and-go/lexer"
+	"github.com/bodneyc/knit-and-go/parser"
+	"github.com/bodneyc/knit-and-go/printer"
+	"github.com/bodneyc/knit-and-go/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// Each file is parsed on its own, so its comments and layout are its own,
+// imports are still found to check the file parses
+func formatFile(args *util.CliArgs, infile string) (string, string, error) {
+	l, err := lexer.NewLexer([]string{infile})
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to create lexer\n%w", err)
+	}
+	p := parser.NewParser(*l)
+	p.AddImportPaths(args.ImportPaths...)
+	err = p.Parse()
+	var diags parser.Diagnostics
+	if errors.As(err, &diags) {
+		diags.WriteWithTexts(os.Stderr, l.Texts())
+		return "", "", fmt.Errorf("Failed to parse %s, %d error(s)", infile, len(diags))
+	} else if err != nil {
+		return "", "", fmt.Errorf("Failed to parse %s\n%w", infile, err)
+	}
+
+	var before string
+	if infile == "-" {
+		before = l.Texts()[lexer.STDIN_NAME]
+	} else {
+		b, err := ioutil.ReadFile(infile)
+		if err != nil {
+			return "", "", fmt.Errorf("Couldn't read %s\n%w", infile, err)
+		}
+		before = string(b)
+	}
+
+	after, err := printer.Sprint(&p.Root, p.Comments())
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to print %s\n%w", infile, err)
+	}
+	return before, after, nil
+}
+
+// `fmt` subcommand, the exit code is returned
+func formatFiles(args *util.CliArgs) int {
+	// Standard output is the formatted source
+	log.SetOutput(os.Stderr)
+
+	code := SUCCESS_EX
+	for _, infile := range args.Infiles {
+		before, after, err := formatFile(args, infile)
+		if err != nil {
+			log.Error(err)
+			code = PARSER_EX
+			continue
+		}
+
+		name := infile
+		if infile == "-" {
+			name = lexer.STDIN_NAME
+		}
+		switch {
+		case args.Diff:
+			fmt.Print(util.UnifiedDiff(name+".orig", name, before, after))
+		case args.Write:
+			if before == after {
+				continue
+			}
+			log.WithField("infile", infile).Info("Rewriting")
+			if err := ioutil.WriteFile(infile, []byte(after), 0644); err != nil {
+				log.Errorf("Couldn't write %s\n%v", infile, err)
+				code = FILESYS_EX
+			}
+		default:
+			fmt.Print(after)
+		}
+	}
+	return code
+}
@@ -4,33 +4,100 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
-
-	// "strings"
+	"strings"
 	"unicode"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// Name given to standard input in positions and messages
+const STDIN_NAME = "<stdin>"
+
+// A named input, opened only once the lexer reaches it
+type Source struct {
+	Name string
+	open func() (io.ReadCloser, error)
+	// Kept so diagnostics can quote inputs that can't be read again
+	keep bool
+}
+
+func FileSource(path string) Source {
+	return Source{
+		Name: path,
+		open: func() (io.ReadCloser, error) { return os.Open(path) },
+	}
+}
+
+// The reader is never closed, that's left to the caller
+func ReaderSource(name string, r io.Reader) Source {
+	return Source{
+		Name: name,
+		open: func() (io.ReadCloser, error) { return ioutil.NopCloser(r), nil },
+		keep: true,
+	}
+}
+
+func StringSource(name string, text string) Source {
+	return ReaderSource(name, strings.NewReader(text))
+}
+
+func StdinSource() Source {
+	return ReaderSource(STDIN_NAME, os.Stdin)
+}
+
+// "-" is standard input, anything else a path
+func PathSource(path string) Source {
+	if path == "-" {
+		return StdinSource()
+	}
+	return FileSource(path)
+}
+
 type Lexer struct {
-	infiles    []string
+	sources    []Source
 	inputIdx   int
-	file       *os.File
+	closer     io.Closer
 	reader     *bufio.Reader
+	texts      map[string]*bytes.Buffer
 	pos        Position
+	prev       Position
 	override   bool
 	overridden TokenContainer
+	// Every comment read, in order, so they can be put back by a printer
+	comments []TokenContainer
 }
 
 func NewLexer(infiles []string) (*Lexer, error) {
+	sources := make([]Source, len(infiles))
+	for i, infile := range infiles {
+		sources[i] = PathSource(infile)
+	}
+	return NewLexerFromSources(sources...)
+}
+
+func NewLexerFromReader(name string, r io.Reader) (*Lexer, error) {
+	return NewLexerFromSources(ReaderSource(name, r))
+}
+
+func NewLexerFromString(name string, text string) (*Lexer, error) {
+	return NewLexerFromSources(StringSource(name, text))
+}
+
+func NewLexerFromSources(sources ...Source) (*Lexer, error) {
 	l := &Lexer{
-		infiles:    infiles,
+		sources:    sources,
 		inputIdx:   0,
-		file:       nil,
+		closer:     nil,
 		reader:     nil,
+		texts:      make(map[string]*bytes.Buffer),
 		pos:        Position{Line: 1, Column: 0},
+		prev:       Position{Line: 1, Column: 0},
 		override:   false,
 		overridden: TokenContainer{},
+		comments:   make([]TokenContainer, 0),
 	}
 	suc, err := l.readNextInput()
 	if err != nil {
@@ -43,31 +110,64 @@ func NewLexer(infiles []string) (*Lexer, error) {
 }
 
 func (l *Lexer) readNextInput() (bool, error) {
-	if len(l.infiles) == l.inputIdx {
+	if len(l.sources) == l.inputIdx {
 		return false, nil
 	}
 
-	if l.file != nil {
-		if err := l.file.Close(); err != nil {
+	if l.closer != nil {
+		if err := l.closer.Close(); err != nil {
 			return false, err
 		}
+		l.closer = nil
 	}
 
-	log.WithField("infile", l.infiles[l.inputIdx]).Info("Attempting to open input")
+	source := l.sources[l.inputIdx]
+	log.WithField("infile", source.Name).Info("Attempting to open input")
 
-	file, err := os.Open(l.infiles[l.inputIdx])
+	rc, err := source.open()
 	if err != nil {
 		return false, err
 	}
 
-	l.file = file
-	l.reader = bufio.NewReader(file)
+	var r io.Reader = rc
+	if source.keep {
+		text := &bytes.Buffer{}
+		l.texts[source.Name] = text
+		r = io.TeeReader(rc, text)
+	}
+
+	l.closer = rc
+	l.reader = bufio.NewReader(r)
+	l.pos = Position{File: source.Name, Line: 1, Column: 0}
+	l.prev = l.pos
 
 	l.inputIdx++
 
 	return true, nil
 }
 
+// The input currently being lexed, empty if none has been opened
+func (l *Lexer) CurrentFile() string {
+	if l.inputIdx == 0 {
+		return ""
+	}
+	return l.sources[l.inputIdx-1].Name
+}
+
+// Text read so far from inputs that aren't files, by source name
+func (l *Lexer) Texts() map[string]string {
+	texts := make(map[string]string, len(l.texts))
+	for name, text := range l.texts {
+		texts[name] = text.String()
+	}
+	return texts
+}
+
+// Comments read so far, including those the parser skips over
+func (l *Lexer) Comments() []TokenContainer {
+	return l.comments
+}
+
 func (l *Lexer) Peek() TokenContainer {
 	if !l.override {
 		l.overridden = l.Next()
@@ -82,6 +182,7 @@ func (l *Lexer) Next() TokenContainer {
 		return l.overridden
 	}
 
+	start := l.pos
 	r := l.read()
 
 	pos := l.pos
@@ -102,15 +203,20 @@ func (l *Lexer) Next() TokenContainer {
 	if r == COMMENT_LITERAL {
 		log.WithField("literal", ";").Trace("[Lexer.Next]")
 		tok, str := l.lexComment()
-		return NewTokenContainer(pos, tok, str)
+		comment := NewTokenContainer(pos, tok, str)
+		l.comments = append(l.comments, comment)
+		return comment
 	}
 
 	if tok, _ := l.lexFor(r, isWhiteSpace, WHITE_SPACE_T); tok != ILLEGAL_T {
 		return l.Next()
 	}
 
+	// A line break is at the end of the line it breaks, not the start of the next
 	if tok, str := l.lexFor(r, isLineBreak, NEW_LINE_T); tok != ILLEGAL_T {
-		return NewTokenContainer(pos, tok, str)
+		end := start
+		end.Column++
+		return NewTokenContainer(end, tok, str)
 	}
 
 	// isLetter, then isIdentifier
@@ -124,6 +230,11 @@ func (l *Lexer) Next() TokenContainer {
 		return NewTokenContainer(pos, tok, str)
 	}
 
+	if r == COLOUR_LITERAL {
+		tok, str := l.lexColour()
+		return NewTokenContainer(pos, tok, str)
+	}
+
 	rp := l.read()
 	if r == ':' && rp == '=' {
 		return NewTokenContainer(pos, ALIAS_T, ":=")
@@ -135,6 +246,91 @@ func (l *Lexer) Next() TokenContainer {
 	return NewTokenContainer(pos, tok, str)
 }
 
+// Opening '"' already consumed, reads up to the closing '"' taking `\"`, `\\`,
+// `\n` and `\t` as escapes
+func (l *Lexer) NextQuoted() (TokenContainer, error) {
+	pos := l.pos
+	var buf bytes.Buffer
+
+	r := l.read()
+	for r != '"' {
+		if !isNotEol(r) {
+			l.unread(r)
+			return NewTokenContainer(pos, ILLEGAL_T, buf.String()),
+				ErrorAt(pos, "", "Unterminated string")
+		}
+		if r == '\\' {
+			escPos := l.pos
+			r = l.read()
+			switch r {
+			case '"', '\\':
+			case 'n':
+				r = '\n'
+			case 't':
+				r = '\t'
+			default:
+				if !isNotEol(r) {
+					l.unread(r)
+					return NewTokenContainer(pos, ILLEGAL_T, buf.String()),
+						ErrorAt(pos, "", "Unterminated string")
+				}
+				return NewTokenContainer(pos, ILLEGAL_T, buf.String()),
+					ErrorAt(escPos, "", "Unknown escape \\%c in string", r)
+			}
+		}
+		buf.WriteRune(r)
+		r = l.read()
+	}
+
+	log.WithField("literal", buf.String()).Trace("[Lexer.NextQuoted]")
+	return NewTokenContainer(pos, STRING_T, buf.String()), nil
+}
+
+// The rest of the line as it's written, for text that isn't made of tokens,
+// the line break is consumed but not included
+func (l *Lexer) NextLine() TokenContainer {
+	if l.override {
+		log.Warn("Reading a line after a peek, the peeked token is lost")
+		l.override = false
+	}
+
+	pos := l.pos
+	pos.Column++
+	var buf bytes.Buffer
+
+	r := l.read()
+	if r == EOF_LITERAL {
+		l.unread(r)
+		return NewTokenContainer(pos, EOF_T, ":EOF:")
+	}
+	for isNotEol(r) {
+		buf.WriteRune(r)
+		r = l.read()
+	}
+	if r == '\r' {
+		if r = l.read(); r != '\n' {
+			l.unread(r)
+		}
+	} else if r == EOF_LITERAL {
+		l.unread(r)
+	}
+
+	log.WithField("literal", buf.String()).Trace("[Lexer.NextLine]")
+	return NewTokenContainer(pos, STRING_T, buf.String())
+}
+
+// '#' already consumed, the digits are checked by the parser
+func (l *Lexer) lexColour() (Token, string) {
+	r := l.read()
+	if !isHex(r) {
+		l.unread(r)
+		return COLOUR_T, string(COLOUR_LITERAL)
+	}
+	_, digits := l.lexFor(r, isHex, COLOUR_T)
+	log.WithField("literal", digits).Trace("[Lexer.lexColour]")
+	return COLOUR_T, string(COLOUR_LITERAL) + digits
+}
+
 func (l *Lexer) lexComment() (Token, string) {
 	var buf bytes.Buffer
 
@@ -193,6 +389,12 @@ func (l *Lexer) lexGrammar(r rune) (Token, string) {
 		token = RIGHT_BRACE_T
 	case '=':
 		token = EQUALS_T
+	case '/':
+		token = SLASH_T
+	case '|':
+		token = PIPE_T
+	case '+':
+		token = PLUS_T
 	default:
 		token = ILLEGAL_T
 	}
@@ -219,6 +421,7 @@ func (l *Lexer) lexIdentifier(r rune) (Token, string) {
 }
 
 func (l *Lexer) read() rune {
+	l.prev = l.pos
 	if r, _, err := l.reader.ReadRune(); err != nil {
 		return EOF_LITERAL
 	} else {
@@ -227,8 +430,9 @@ func (l *Lexer) read() rune {
 	}
 }
 
+// Only ever follows a single read
 func (l *Lexer) unread(r rune) {
-	l.pos.dec(r)
+	l.pos = l.prev
 	_ = l.reader.UnreadRune()
 }
 
@@ -248,6 +452,10 @@ func isNumeric(r rune) bool {
 	return unicode.IsDigit(r) || r == '.'
 }
 
+func isHex(r rune) bool {
+	return unicode.Is(unicode.ASCII_Hex_Digit, r)
+}
+
 func isLineBreak(r rune) bool {
 	return r == '\n' || r == '\r'
 }
@@ -1,10 +1,16 @@
 package lexer
 
-import "fmt"
+import (
+	"fmt"
 
+	"github.com/bodneyc/knit-and-go/util"
+)
+
+// Lines and columns count from 1 in each input, `File` is as it was given
 type Position struct {
-	Line   int
-	Column int
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
 }
 
 func (p *Position) inc(r rune) {
@@ -16,20 +22,47 @@ func (p *Position) inc(r rune) {
 	}
 }
 
-func (p *Position) dec(r rune) {
-	if isLineBreak(r) {
-		p.Line--
-		p.Column = -1
-	} else {
-		p.Column--
+// `file:line:col`, or just `line:col` if the file isn't known
+func (p Position) Str() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
 	}
-}
-
-func (p *Position) Str() string {
-	return fmt.Sprint("(", p.Line, ":", p.Column, ")")
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
 }
 
 var NO_POS = Position{
+	File:   "",
 	Line:   -1,
 	Column: -1,
 }
+
+// A mistake in the pattern at `At`, found by something other than the parser,
+// which shows it as a diagnostic there; one of the pattern as a whole is at
+// `NO_POS`
+type PosError struct {
+	At    Position
+	Msg   string
+	Hint  string
+	trace string
+}
+
+func (e *PosError) Error() string {
+	if e.At.Line < 1 {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s: %s", e.At.Str(), e.Msg)
+}
+
+// Where the error was made, only of use to us
+func (e *PosError) StackTrace() string {
+	return e.trace
+}
+
+func ErrorAt(at Position, hint string, format string, a ...interface{}) error {
+	return &PosError{
+		At:    at,
+		Msg:   fmt.Sprintf(format, a...),
+		Hint:  hint,
+		trace: util.StackLineN(2),
+	}
+}
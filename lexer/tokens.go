@@ -10,6 +10,7 @@ import (
 const EOF_LITERAL rune = rune(0)
 const LF_LITERAL rune = rune(10)
 const COMMENT_LITERAL rune = ';'
+const COLOUR_LITERAL rune = '#'
 
 type TokenContainer struct {
 	Pos Position
@@ -56,6 +57,7 @@ const (
 	COMMENT_T
 	IDENTIFIER_T
 	NUMERIC_T
+	STRING_T
 
 	MINUS_T
 	ASTERISK_T
@@ -69,4 +71,10 @@ const (
 	RIGHT_BRACE_T
 	EQUALS_T
 	ALIAS_T
+	SLASH_T
+	PIPE_T
+	PLUS_T
+
+	// `#1b2a4a`
+	COLOUR_T
 )
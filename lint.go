@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/bodneyc/knit-and-go/lexer"
+	"github.com/bodneyc/knit-and-go/lint"
+	"github.com/bodneyc/knit-and-go/parser"
+	"github.com/bodneyc/knit-and-go/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// `lint` subcommand, the inputs are parsed together as they would be to run
+// them, the exit code is returned
+func lintFiles(args *util.CliArgs) int {
+	// Standard output is the findings
+	log.SetOutput(os.Stderr)
+
+	config, err := lint.NewConfig(args.Rules)
+	if err != nil {
+		log.Error(err)
+		return OPTION_EX
+	}
+
+	l, err := lexer.NewLexer(args.Infiles)
+	if err != nil {
+		log.Errorf("Failed to create lexer\n%v", err)
+		return LEXER_EX
+	}
+	p := parser.NewParser(*l)
+	p.AddImportPaths(args.ImportPaths...)
+	err = p.Parse()
+	var diags parser.Diagnostics
+	if errors.As(err, &diags) {
+		diags.WriteWithTexts(os.Stderr, l.Texts())
+		log.Errorf("Failed to parse input, %d error(s)", len(diags))
+		return PARSER_EX
+	} else if err != nil {
+		log.Errorf("Failed to parse input file\n%v", err)
+		return PARSER_EX
+	}
+
+	findings := lint.Lint(&p.Root, p.Comments(), config)
+	if args.Json {
+		if err := findings.WriteJson(os.Stdout); err != nil {
+			log.Error(err)
+			return GENERIC_EX
+		}
+	} else {
+		findings.WriteWithTexts(os.Stdout, l.Texts())
+	}
+
+	if findings.Failed() {
+		log.Errorf("Lint failed, %d finding(s)", len(findings))
+		return LINT_EX
+	}
+	return SUCCESS_EX
+}
@@ -0,0 +1,475 @@
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/bodneyc/knit-and-go/ast"
+	. "github.com/bodneyc/knit-and-go/lexer"
+	"github.com/bodneyc/knit-and-go/parser"
+	. "github.com/bodneyc/knit-and-go/util"
+)
+
+// ------------------ Findings ------------------
+
+type Finding struct {
+	parser.Diagnostic
+	Rule string `json:"rule"`
+}
+
+type Findings []*Finding
+
+// Whether any finding is an error rather than a warning
+func (f Findings) Failed() bool {
+	for _, finding := range f {
+		if finding.Severity == severityText(ERROR_CL) {
+			return true
+		}
+	}
+	return false
+}
+
+// As the parser's diagnostics, with the rule after the message
+func (f Findings) WriteWithTexts(w io.Writer, texts map[string]string) {
+	diags := make(parser.Diagnostics, len(f))
+	for i, finding := range f {
+		diag := finding.Diagnostic
+		diag.Msg = fmt.Sprintf("%s [%s]", diag.Msg, finding.Rule)
+		diags[i] = &diag
+	}
+	diags.WriteWithTexts(w, texts)
+}
+
+func (f Findings) WriteJson(w io.Writer) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%w%s", err, StackLine())
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// ------------------ Pragmas ------------------
+
+const (
+	IGNORE_PRAGMA      = "lint:ignore"
+	FILE_IGNORE_PRAGMA = "lint:file-ignore"
+)
+
+type lineKey struct {
+	file string
+	line int
+}
+
+// Rules switched off by comments, `; lint:ignore unused-alias` for its own
+// line and the next, `; lint:file-ignore shadowed` for the whole file, no
+// rules for all of them
+type pragmas struct {
+	lines map[lineKey][]string
+	files map[string][]string
+}
+
+func pragmaRules(text string) []string {
+	rules := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	if len(rules) == 0 {
+		return []string{""}
+	}
+	return rules
+}
+
+func readPragmas(comments []ast.CommentExpr) pragmas {
+	p := pragmas{lines: make(map[lineKey][]string), files: make(map[string][]string)}
+	for _, c := range comments {
+		text := strings.TrimSpace(strings.TrimLeft(c.Str, ";"))
+		at := c.Semicolon
+		switch {
+		case strings.HasPrefix(text, FILE_IGNORE_PRAGMA):
+			rules := pragmaRules(text[len(FILE_IGNORE_PRAGMA):])
+			p.files[at.File] = append(p.files[at.File], rules...)
+		case strings.HasPrefix(text, IGNORE_PRAGMA):
+			rules := pragmaRules(text[len(IGNORE_PRAGMA):])
+			for _, line := range []int{at.Line, at.Line + 1} {
+				key := lineKey{at.File, line}
+				p.lines[key] = append(p.lines[key], rules...)
+			}
+		}
+	}
+	return p
+}
+
+func matchesRule(rules []string, rule string) bool {
+	for _, r := range rules {
+		if r == "" || r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func (p pragmas) ignores(f *Finding) bool {
+	return matchesRule(p.files[f.Pos.File], f.Rule) ||
+		matchesRule(p.lines[lineKey{f.Pos.File, f.Pos.Line}], f.Rule)
+}
+
+// ------------------ Linter ------------------
+
+type declKind string
+
+const (
+	ALIAS_DK  declKind = "alias"
+	ASSIGN_DK declKind = "assignment"
+	NUMBER_DK declKind = "number"
+	PARAM_DK  declKind = "parameter"
+)
+
+type decl struct {
+	kind   declKind
+	name   ast.IdentExpr
+	assign *ast.AssignStmt
+	// What an alias names
+	rhs string
+	// From an import rather than the pattern itself, nothing's reported in it
+	imported bool
+	used     bool
+}
+
+// Stitch definitions sit alongside the other declarations, as they do in the
+// engine, so they're kept apart
+type scope struct {
+	parent   *scope
+	decls    map[string]*decl
+	stitches map[string]bool
+	// Walked once the scope is complete, an assignment can call anything
+	// declared in its scope by the time it's called
+	assigns []*decl
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{
+		parent:   parent,
+		decls:    make(map[string]*decl),
+		stitches: make(map[string]bool),
+		assigns:  make([]*decl, 0),
+	}
+}
+
+type linter struct {
+	scope    *scope
+	imported int
+	found    Findings
+	all      []*decl
+	mainYarn bool
+}
+
+// Everything found in the pattern, rather than anything it imports, by the
+// severities of `config` and leaving out those ignored by `comments`
+func Lint(root *ast.BlockStmt, comments []ast.CommentExpr, config Config) Findings {
+	l := &linter{found: make(Findings, 0), all: make([]*decl, 0)}
+	l.block(root.Block)
+	l.unused()
+
+	ignored := readPragmas(comments)
+	findings := make(Findings, 0, len(l.found))
+	for _, f := range l.found {
+		level := config[f.Rule]
+		if level == OFF_CL || ignored.ignores(f) {
+			continue
+		}
+		f.Severity = severityText(level)
+		findings = append(findings, f)
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i].Pos, findings[j].Pos
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return findings
+}
+
+func (l *linter) report(rule string, at Position, hint string, format string, a ...interface{}) {
+	if l.imported > 0 {
+		return
+	}
+	l.found = append(l.found, &Finding{
+		Diagnostic: parser.Diagnostic{Pos: at, Msg: fmt.Sprintf(format, a...), Hint: hint},
+		Rule:       rule,
+	})
+}
+
+func (l *linter) push() {
+	l.scope = newScope(l.scope)
+}
+
+// Assignments are walked before the scope goes, with what it declared after
+// them
+func (l *linter) pop() {
+	for i := 0; i < len(l.scope.assigns); i++ {
+		l.assignRhs(l.scope.assigns[i])
+	}
+	l.scope = l.scope.parent
+}
+
+func (l *linter) block(stmts []ast.Stmt) {
+	l.push()
+	defer l.pop()
+	for _, stmt := range stmts {
+		l.stmt(stmt)
+	}
+}
+
+func (l *linter) declare(kind declKind, name ast.IdentExpr) *decl {
+	d := &decl{kind: kind, name: name, imported: l.imported > 0}
+	for s := l.scope.parent; s != nil; s = s.parent {
+		if outer, ok := s.decls[name.Name]; ok {
+			l.report(SHADOWED_RULE, name.At, "", "%s shadows the %s declared at %s",
+				name.Name, outer.kind, outer.name.At.Str())
+			break
+		}
+	}
+	l.scope.decls[name.Name] = d
+	l.all = append(l.all, d)
+	return d
+}
+
+func (l *linter) stmt(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case *ast.AliasStmt:
+		d := l.declare(ALIAS_DK, s.Lhs)
+		d.rhs = s.Rhs.Name
+		// The first yarn is worked without being named
+		if _, ok := s.Hex(); ok && !l.mainYarn {
+			l.mainYarn, d.used = true, true
+		}
+
+	case *ast.AssignStmt:
+		d := l.declare(ASSIGN_DK, s.Lhs)
+		d.assign = s
+		l.scope.assigns = append(l.scope.assigns, d)
+
+	case *ast.NumberStmt:
+		l.expr(s.Rhs)
+		l.declare(NUMBER_DK, s.Lhs)
+
+	case *ast.StitchStmt:
+		l.scope.stitches[s.Name.Name] = true
+
+	case *ast.RowStmt:
+		l.expr(&s.Row)
+
+	case *ast.GroupStmt:
+		l.expr(&s.Group)
+
+	case *ast.BlockStmt:
+		l.block(s.Block)
+
+	case *ast.GaugeStmt:
+		if s.Over != nil {
+			l.expr(s.Over)
+		}
+
+	// Only what an import declares is used, as in the engine
+	case *ast.ImportStmt:
+		if s.Block == nil {
+			return
+		}
+		l.imported++
+		for _, stmt := range s.Block.Block {
+			switch stmt.(type) {
+			case *ast.AliasStmt, *ast.AssignStmt, *ast.StitchStmt, *ast.NumberStmt, *ast.GaugeStmt, *ast.ImportStmt:
+				l.stmt(stmt)
+			}
+		}
+		l.imported--
+	}
+}
+
+// Parameters are a scope of their own, between the rhs and where it was
+// declared
+func (l *linter) assignRhs(d *decl) {
+	if d.imported {
+		l.imported++
+		defer func() { l.imported-- }()
+	}
+	l.push()
+	defer l.pop()
+	for _, param := range d.assign.Params {
+		l.declare(PARAM_DK, param).used = true
+	}
+	l.expr(d.assign.Rhs)
+}
+
+func (l *linter) args(args ast.Brackets) {
+	for _, arg := range args.Args {
+		l.expr(arg)
+	}
+}
+
+func (l *linter) expr(expr ast.Expr) {
+	switch x := expr.(type) {
+	case *ast.RowExpr:
+		for _, stitch := range x.Stitches {
+			l.expr(stitch)
+		}
+		l.args(x.Args)
+
+	case *ast.GroupExpr:
+		l.args(x.Args)
+		l.block(x.Lines)
+
+	case *ast.StitchExpr:
+		l.use(x.Id, true)
+		l.args(x.Args)
+
+	case *ast.IdentExpr:
+		l.use(*x, false)
+
+	// Only a name, rather than a number or a measurement
+	case *ast.SizeExpr:
+		if x.Ni == -1 && x.Nf == -1 && x.Unit != ast.ASTERISK {
+			l.use(x.Id, false)
+		}
+
+	case *ast.VariantExpr:
+		for _, value := range x.Values {
+			l.expr(value)
+		}
+
+	case *ast.ArithExpr:
+		l.expr(x.X)
+		l.expr(x.Y)
+	}
+}
+
+// Only stitches are reported if they're not declared, an argument is often a
+// word of the instructions, `use(circular)`
+func (l *linter) use(id ast.IdentExpr, stitch bool) {
+	if id.Name == "" {
+		return
+	}
+	for s := l.scope; s != nil; s = s.parent {
+		if d, ok := s.decls[id.Name]; ok {
+			l.follow(d)
+			return
+		}
+		if s.stitches[id.Name] {
+			return
+		}
+	}
+	if _, ok := ast.BuiltinStitch(id.Name); ok || !stitch {
+		return
+	}
+	hint := ""
+	if near, ok := l.nearest(id.Name); ok {
+		hint = fmt.Sprintf("did you mean %s?", near)
+	}
+	l.report(UNDECLARED_RULE, id.At, hint,
+		"%s isn't declared or a known stitch, it's printed as it's written", id.Name)
+}
+
+// Using an alias uses what it names too, as far as the chain goes
+func (l *linter) follow(d *decl) {
+	seen := make(map[*decl]bool)
+	for d != nil && !seen[d] {
+		seen[d] = true
+		d.used = true
+		if d.kind != ALIAS_DK {
+			return
+		}
+		d = l.lookup(d.rhs)
+	}
+}
+
+func (l *linter) lookup(name string) *decl {
+	for s := l.scope; s != nil; s = s.parent {
+		if d, ok := s.decls[name]; ok {
+			return d
+		}
+	}
+	return nil
+}
+
+// Aliases and assignments are only worth reporting in the pattern, parameters
+// are often there for a call's sake alone
+func (l *linter) unused() {
+	for _, d := range l.all {
+		if d.used || d.imported {
+			continue
+		}
+		switch d.kind {
+		case ALIAS_DK:
+			l.report(UNUSED_ALIAS_RULE, d.name.At, "", "Alias %s is never used", d.name.Name)
+		case ASSIGN_DK:
+			l.report(UNUSED_ASSIGN_RULE, d.name.At, "", "%s is assigned but never called", d.name.Name)
+		}
+	}
+}
+
+// ------------------ Suggestions ------------------
+
+// The closest name in scope or builtin, if it's close enough to be a typo
+func (l *linter) nearest(name string) (string, bool) {
+	candidates := ast.BuiltinStitchNames()
+	for s := l.scope; s != nil; s = s.parent {
+		for other := range s.decls {
+			candidates = append(candidates, other)
+		}
+		for other := range s.stitches {
+			candidates = append(candidates, other)
+		}
+	}
+	// Map order isn't kept, so ties go to the first alphabetically
+	sort.Strings(candidates)
+
+	// One edit in a short name, two in a longer
+	best, bestDist := "", 2
+	if len(name) > 4 {
+		bestDist = 3
+	}
+	for _, other := range candidates {
+		if d := distance(strings.ToLower(name), strings.ToLower(other)); d < bestDist {
+			best, bestDist = other, d
+		}
+	}
+	return best, best != ""
+}
+
+// Levenshtein, by rune
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+func min(n int, ns ...int) int {
+	for _, m := range ns {
+		if m < n {
+			n = m
+		}
+	}
+	return n
+}
@@ -0,0 +1,113 @@
+package lint_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bodneyc/knit-and-go/lexer"
+	"github.com/bodneyc/knit-and-go/lint"
+	"github.com/bodneyc/knit-and-go/parser"
+	"github.com/bodneyc/knit-and-go/util"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.ErrorLevel)
+	os.Exit(m.Run())
+}
+
+func findings(t *testing.T, text string, levels map[string]util.CheckLevel) lint.Findings {
+	t.Helper()
+	config, err := lint.NewConfig(levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := lexer.NewLexerFromString("test.knit", text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := parser.NewParser(*l)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("%q doesn't parse: %v", text, err)
+	}
+	return lint.Lint(&p.Root, p.Comments(), config)
+}
+
+// `rule@line` for each finding, in order
+func found(f lint.Findings) string {
+	s := make([]string, len(f))
+	for i, finding := range f {
+		s[i] = finding.Rule + "@" + strings.TrimPrefix(finding.Pos.Str(), "test.knit:")
+	}
+	return strings.Join(s, " ")
+}
+
+func TestIgnore(t *testing.T) {
+	cases := []struct{ text, want string }{
+		{"co(4)\nfrob(2)\n", "undeclared@2:1"},
+		// Its own line and the next, not the one after
+		{"co(4)\n; lint:ignore undeclared\nfrob(2)\nfrob(2)\n", "undeclared@4:1"},
+		{"co(4)\n; lint:ignore shadowed\nfrob(2)\n", "undeclared@3:1"},
+		{"co(4)\n; lint:ignore unused-alias, undeclared\nfrob(2)\n", ""},
+		{"co(4)\n; lint:ignore\nfrob(2)\n", ""},
+		{"; lint:file-ignore undeclared\n\nx := knit\nco(4)\nfrob(2)\n\nfrob(2)\n", "unused-alias@3:1"},
+		{"; lint:file-ignore\n\nx := knit\nco(4)\nfrob(2)\n", ""},
+	}
+	for _, c := range cases {
+		if got := found(findings(t, c.text, nil)); got != c.want {
+			t.Errorf("%q finds %q, want %q", c.text, got, c.want)
+		}
+	}
+}
+
+func TestRuleLevels(t *testing.T) {
+	const text = "x := knit\nco(4)\nfrob(2)\n"
+
+	f := findings(t, text, nil)
+	if found(f) != "unused-alias@1:1 undeclared@3:1" || f.Failed() {
+		t.Errorf("Unexpected findings by default %q, failed: %v", found(f), f.Failed())
+	}
+	for _, finding := range f {
+		if finding.Severity != "warning" {
+			t.Errorf("%s is a %s by default", finding.Rule, finding.Severity)
+		}
+	}
+
+	f = findings(t, text, map[string]util.CheckLevel{"undeclared": util.ERROR_CL, "unused-alias": util.OFF_CL})
+	if found(f) != "undeclared@3:1" || f[0].Severity != "error" || !f.Failed() {
+		t.Errorf("Unexpected findings with -r undeclared=error -r unused-alias=off %q", found(f))
+	}
+
+	if _, err := lint.NewConfig(map[string]util.CheckLevel{"unused": util.WARN_CL}); err == nil ||
+		!strings.Contains(err.Error(), "Unknown lint rule unused") {
+		t.Errorf("Unknown rule gave %v", err)
+	}
+}
+
+func TestWriteJson(t *testing.T) {
+	var b bytes.Buffer
+	if err := findings(t, "co(4)\nfrob(2)\n", nil).WriteJson(&b); err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatalf("%v\n%s", err, b.String())
+	}
+	want := []map[string]interface{}{{
+		"rule":     "undeclared",
+		"severity": "warning",
+		"message":  got[0]["message"],
+		"hint":     got[0]["hint"],
+		"pos":      map[string]interface{}{"file": "test.knit", "line": 2.0, "column": 1.0},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON is\n%s", b.String())
+	}
+	if msg, _ := got[0]["message"].(string); !strings.Contains(msg, "frob") {
+		t.Errorf("Message %q doesn't name the stitch", msg)
+	}
+}
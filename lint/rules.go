@@ -0,0 +1,73 @@
+package lint
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/bodneyc/knit-and-go/util"
+)
+
+// ------------------ Rules ------------------
+
+const (
+	UNDECLARED_RULE    = "undeclared"
+	UNUSED_ALIAS_RULE  = "unused-alias"
+	UNUSED_ASSIGN_RULE = "unused-assign"
+	SHADOWED_RULE      = "shadowed"
+)
+
+// What a rule is for, and how much it matters unless told otherwise
+type Rule struct {
+	Name     string
+	Severity CheckLevel
+	Doc      string
+}
+
+var Rules = []Rule{
+	{UNDECLARED_RULE, WARN_CL, "A stitch that's neither declared nor builtin, it's printed as it's written"},
+	{UNUSED_ALIAS_RULE, WARN_CL, "An alias that's never used"},
+	{UNUSED_ASSIGN_RULE, WARN_CL, "An assignment that's never called"},
+	{SHADOWED_RULE, WARN_CL, "A declaration hiding one of the same name from an enclosing block"},
+}
+
+func lookupRule(name string) (Rule, bool) {
+	for _, rule := range Rules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+func ruleNames() string {
+	names := make([]string, len(Rules))
+	for i, rule := range Rules {
+		names[i] = rule.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// The severity of each rule, those not given keep their own
+type Config map[string]CheckLevel
+
+func NewConfig(levels map[string]CheckLevel) (Config, error) {
+	config := make(Config)
+	for _, rule := range Rules {
+		config[rule.Name] = rule.Severity
+	}
+	for name, level := range levels {
+		if _, ok := lookupRule(name); !ok {
+			// Shown to the user as it is
+			return nil, fmt.Errorf("Unknown lint rule %s, expected one of %s", name, ruleNames())
+		}
+		config[name] = level
+	}
+	return config, nil
+}
+
+func severityText(level CheckLevel) string {
+	if level == ERROR_CL {
+		return "error"
+	}
+	return "warning"
+}
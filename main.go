@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -22,6 +23,7 @@ const (
 	LEXER_EX
 	PARSER_EX
 	RUN_EX
+	LINT_EX
 )
 
 func configureLogger(logLevelCli string, timings bool) error {
@@ -54,6 +56,14 @@ func configureLogger(logLevelCli string, timings bool) error {
 	return nil
 }
 
+// Compiler style, where in the code each came from is only of use to us
+func writeDiagnostics(diags parser.Diagnostics, texts map[string]string) {
+	diags.WriteWithTexts(os.Stderr, texts)
+	for _, diag := range diags {
+		log.Trace(diag.StackTrace())
+	}
+}
+
 func main() {
 	args, err := util.ParseCli()
 	if err != nil {
@@ -64,8 +74,31 @@ func main() {
 		log.Fatalf("Failed to set log level\n%v", err)
 	}
 
+	switch args.Command {
+	case util.FMT_CMD:
+		os.Exit(formatFiles(args))
+	case util.LINT_CMD:
+		os.Exit(lintFiles(args))
+	}
+
 	log.Info("Starting knit compiler")
 	var p *parser.Parser
+	// Stand in for inputs that can't be read again to show errors
+	var texts map[string]string
+
+	// A states file is resumed by compiling the pattern it was saved from again
+	var saved *ast.SavedStates
+	if args.Inform == util.STATES_IOF {
+		log.WithField("statesfile", args.StatesFile).Info("Reading states file")
+		states, err := ast.ReadStatesFile(args.StatesFile)
+		if err != nil {
+			log.Fatalf("Couldn't read states file\n%v", err)
+		}
+		if err := resumeArgs(args, states.Source); err != nil {
+			log.Fatalf("Couldn't resume from states file\n%v", err)
+		}
+		saved = &states
+	}
 
 	switch args.Inform {
 	case util.KNIT_IOF:
@@ -76,27 +109,28 @@ func main() {
 			log.Fatalf("Failed to create lexer\n%v", err)
 		}
 		p = parser.NewParser(*l)
+		p.AddImportPaths(args.ImportPaths...)
 		err = p.Parse()
-		if err != nil {
+		texts = l.Texts()
+		var diags parser.Diagnostics
+		if errors.As(err, &diags) {
+			writeDiagnostics(diags, texts)
+			log.Errorf("Failed to parse input, %d error(s)", len(diags))
+			os.Exit(PARSER_EX)
+		} else if err != nil {
 			log.Fatalf("Failed to parse input file\n%v", err)
 		}
 
 	case util.AST_IOF:
 		log.WithField("infile", args.Infiles[0]).Info("Attempting to open input")
 
-		file, err := os.Open(args.Infiles[0])
-		if err != nil {
-			log.Error(err)
+		var jsonBytes []byte
+		if args.Infiles[0] == "-" {
+			jsonBytes, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			jsonBytes, err = ioutil.ReadFile(args.Infiles[0])
 		}
-		defer file.Close()
-
-		fStat, err := file.Stat()
 		if err != nil {
-			log.Fatalf("Couldn't stat input JSON\n%v", err)
-		}
-
-		jsonBytes := make([]byte, fStat.Size())
-		if _, err := file.Read(jsonBytes); err != nil {
 			log.Fatalf("Couldn't read input JSON\n%v", err)
 		}
 
@@ -111,55 +145,86 @@ func main() {
 	}
 
 	var engine ast.Engine
-	if args.Inform == util.STATES_IOF {
-		engine, err = ast.MakeEngineFromStatesFile(args.StatesFile)
+	if args.AstFile != "" {
+		log.Info("Marshalling...")
+		rootJson, err := json.MarshalIndent(p.Root, "", "  ")
 		if err != nil {
-			log.Fatalf("Error during walk for locals\n%#v", err)
+			panic(err)
 		}
-	} else {
-		if args.AstFile != "" {
-			log.Info("Marshalling...")
-			rootJson, err := json.MarshalIndent(p.Root, "", "  ")
-			if err != nil {
-				panic(err)
-			}
-			log.Info("Marshalling complete")
-			log.WithField("astfile", args.AstFile).Info("Writing to file")
-			if err := ioutil.WriteFile(args.AstFile, rootJson, 0644); err != nil {
-				log.Error("Failed to write to root.json", err)
-			}
-			log.WithField("astfile", args.AstFile).Info("File written")
+		log.Info("Marshalling complete")
+		log.WithField("astfile", args.AstFile).Info("Writing to file")
+		if err := ioutil.WriteFile(args.AstFile, rootJson, 0644); err != nil {
+			log.Error("Failed to write to root.json", err)
 		}
+		log.WithField("astfile", args.AstFile).Info("File written")
+	}
 
-		log.Info("Creating engine data")
-		engineData := ast.NewEngineData()
-		p.WalkForLocals(engineData)
-		if err := p.WalkForLines(engineData); err != nil {
-			log.Fatalf("Error during walk for locals\n%#v", err)
+	log.Info("Creating engine data")
+	engineData := ast.NewEngineData()
+	engineData.SelectSize(args.Size)
+	engineData.AllowRecursion(args.RecursionDepth)
+	p.WalkForLocals(engineData)
+	if err := p.WalkForLines(engineData); err != nil {
+		var diags parser.Diagnostics
+		if errors.As(err, &diags) {
+			writeDiagnostics(diags, texts)
+			log.Errorf("Failed to compile pattern, %d error(s)", len(diags))
+			os.Exit(RUN_EX)
 		}
+		log.Fatalf("Error during walk for lines\n%v", err)
+	}
+	for _, err := range engineData.CheckYarns() {
+		log.Warn(err)
+	}
 
-		if args.PrintEngineData {
-			engineData.PrintLines()
-		}
+	if args.PrintEngineData {
+		engineData.PrintLines()
+	}
 
-		log.Info("Creating engine from data")
-		engine = ast.MakeEngine(engineData, args.StatesFile)
-		engine.FormStates()
+	log.Info("Creating engine from data")
+	engine = ast.MakeEngine(engineData, args.StatesFile)
+	engine.Source = patternSource(args)
+	engine.FormStates()
+
+	for _, err := range engine.CheckSides() {
+		log.Warn(err)
+	}
 
-		if args.PrintStates {
-			engine.PrintEngine()
+	if args.StitchCheck != util.OFF_CL {
+		errs := engine.CountStitches()
+		for _, err := range errs {
+			log.Warn(err)
+		}
+		if args.StitchCheck == util.ERROR_CL && len(errs) > 0 {
+			log.Fatalf("Stitch counts don't add up, %d error(s)", len(errs))
 		}
 
-		if args.StatesFile != "" {
-			log.WithField("statesfile", args.StatesFile).Info("Writing to file")
-			err = engine.WriteEngine()
-			if err != nil {
-				log.WithField("statesfile", args.StatesFile).Fatal("Could not write file")
+		// Floats need the stitches counted
+		if args.MaxFloat > 0 {
+			for _, err := range engine.CheckFloats(args.MaxFloat) {
+				log.Warn(err)
 			}
-			log.WithField("statesfile", args.StatesFile).Info("File written")
 		}
 	}
 
+	if saved != nil {
+		engine.Resume(*saved)
+	}
+
+	if args.PrintStates {
+		engine.PrintEngine()
+	}
+
+	// A run picked up from its states file only writes it again when it's saved
+	if args.StatesFile != "" && saved == nil {
+		log.WithField("statesfile", args.StatesFile).Info("Writing to file")
+		err = engine.WriteEngine()
+		if err != nil {
+			log.WithField("statesfile", args.StatesFile).Fatal("Could not write file")
+		}
+		log.WithField("statesfile", args.StatesFile).Info("File written")
+	}
+
 	if args.NoRun {
 		log.Info("No-run option given, exiting...")
 		os.Exit(SUCCESS_EX)
@@ -0,0 +1,317 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/bodneyc/knit-and-go/ast"
+	. "github.com/bodneyc/knit-and-go/lexer"
+	. "github.com/bodneyc/knit-and-go/util"
+)
+
+const CHART_KEYWORD = "chart"
+
+// Either side of the repeat in a row of a chart, unless the legend says it's
+// a stitch
+const CHART_REPEAT_EDGE = '|'
+
+// A symbol's stitch on right side rows and, if given, wrong side rows
+type legendEntry struct {
+	rs, ws ast.IdentExpr
+}
+
+type chartCell struct {
+	at     Position
+	symbol rune
+}
+
+// `chart` identifier already consumed and followed by another identifier, if
+// that isn't followed by a brace it's just a row
+func (p *Parser) parseChartStmtOrRow(desc ast.CommentGroupExpr, firstToken TokenContainer) (ast.Stmt, error) {
+	nameToken, err := p.nextIgnoreWs()
+	if err != nil {
+		return nil, fmt.Errorf("%w%s", err, StackLine())
+	}
+
+	if p.peekIgnoreWs().Tok != LEFT_BRACE_T {
+		first := ast.NewStitchExpr(ast.MakeIdentExpr(firstToken), ast.MakeBrackets())
+		second, err := p.parseSingleStitch(ast.MakeIdentExpr(nameToken))
+		if err != nil {
+			return nil, fmt.Errorf("%w%s", err, StackLine())
+		}
+		row, err := p.parseRowExprFrom([]ast.Expr{first, second}, nameToken, false)
+		if err != nil {
+			return nil, fmt.Errorf("%w%s", err, StackLine())
+		}
+		return ast.NewRowStmt(desc, *row), nil
+	}
+
+	lBrace, _ := p.nextIgnoreWs() // Consume '{'
+	switch t, _ := p.nextIgnoreWs(); t.Tok {
+	case NEW_LINE_T:
+	case COMMENT_T:
+		p.nextIgnoreWs() // Consume '\n'
+	default:
+		return nil, p.errorAt(t.Pos, "the rows of a chart start on the line after its '{'",
+			"Unexpected %s following '{'", describe(t))
+	}
+	return p.parseChart(desc, ast.MakeIdentExpr(nameToken), lBrace)
+}
+
+// The lines of a chart are read as they're written, up to a '}' on its own,
+// those of the form `symbol = stitch` are the legend and the rest are rows
+// from the top of the chart down
+func (p *Parser) parseChart(desc ast.CommentGroupExpr, name ast.IdentExpr, lBrace TokenContainer) (ast.Stmt, error) {
+	legend := make(map[rune]legendEntry)
+	grid := make([][]chartCell, 0)
+	var rBrace Position
+	written := make([]string, 0)
+	for {
+		line := p.lexer.NextLine()
+		if line.Tok == EOF_T {
+			return nil, p.errorAt(lBrace.Pos, "every '{' needs a matching '}'", "Chart %s is never closed", name.Name)
+		}
+		text := strings.TrimSpace(line.Str)
+		if text == "}" {
+			rBrace = line.Pos
+			rBrace.Column += strings.IndexRune(line.Str, '}')
+			break
+		}
+		written = append(written, line.Str)
+		if text == "" || strings.HasPrefix(text, string(COMMENT_LITERAL)) {
+			continue
+		}
+
+		cells := chartCells(line)
+		if symbol, entry, ok, err := p.parseLegendEntry(cells, line); ok {
+			// An error in one line doesn't stop the rest of the chart being checked
+			if err != nil {
+				p.diags = append(p.diags, p.diagnose(err)...)
+			} else if _, seen := legend[symbol]; seen {
+				p.diags = append(p.diags, p.diagnose(p.errorAt(cells[0].at, "",
+					"Symbol %c is in the legend more than once", symbol))...)
+			} else {
+				legend[symbol] = entry
+			}
+			continue
+		}
+		grid = append(grid, cells)
+	}
+	p.last = NewTokenContainer(rBrace, NEW_LINE_T, "\n")
+
+	if len(grid) == 0 {
+		return nil, p.errorAt(lBrace.Pos, "", "Chart %s has no rows", name.Name)
+	}
+
+	// Row 1 is at the bottom, it and every other row are right side rows, in
+	// the round they all are
+	rows := make([]ast.Stmt, len(grid))
+	for i, cells := range grid {
+		rowNum := len(grid) - i
+		rs := rowNum%2 == 1 || p.construction == ast.ROUND
+		row, err := p.chartRow(cells, legend, rs)
+		if err != nil {
+			p.diags = append(p.diags, p.diagnose(err)...)
+			continue
+		}
+		rowDesc := ast.CommentGroupExpr{List: []ast.CommentExpr{{
+			Semicolon: cells[0].at,
+			Str:       fmt.Sprintf("Row %d of %s", rowNum, name.Name),
+		}}}
+		rows[rowNum-1] = ast.NewRowStmt(rowDesc, *row)
+		rows[rowNum-1].(*ast.RowStmt).Side = ast.WRONG_SIDE
+		if rs {
+			rows[rowNum-1].(*ast.RowStmt).Side = ast.RIGHT_SIDE
+		}
+	}
+	lines := make([]ast.Stmt, 0, len(rows))
+	for _, row := range rows {
+		if row != nil {
+			lines = append(lines, row)
+		}
+	}
+
+	group := ast.NewGroupExpr(lBrace.Pos, rBrace, lines, ast.MakeBrackets())
+	chart := ast.NewAssignStmt(desc, name, make([]ast.IdentExpr, 0), group)
+	chart.Chart = written
+	return chart, nil
+}
+
+// Every symbol in a line, spaces only line them up
+func chartCells(line TokenContainer) []chartCell {
+	cells := make([]chartCell, 0, len(line.Str))
+	at := line.Pos
+	for _, r := range line.Str {
+		if !unicode.IsSpace(r) {
+			cells = append(cells, chartCell{at: at, symbol: r})
+		}
+		at.Column++
+	}
+	return cells
+}
+
+// `symbol = stitch` or `symbol = rs-stitch, ws-stitch`, a line that doesn't
+// start with a symbol and '=' is a row
+func (p *Parser) parseLegendEntry(cells []chartCell, line TokenContainer) (rune, legendEntry, bool, error) {
+	var entry legendEntry
+	if len(cells) < 2 || cells[1].symbol != '=' {
+		return 0, entry, false, nil
+	}
+	symbol := cells[0].symbol
+	rest := string([]rune(line.Str)[cells[1].at.Column-line.Pos.Column+1:])
+	restAt := cells[1].at
+	restAt.Column++
+
+	names := strings.Split(rest, ",")
+	if len(names) > 2 {
+		return symbol, entry, true, p.errorAt(restAt, "e.g. . = k, or . = k, p to purl it on wrong side rows",
+			"A symbol has a stitch for each side at most")
+	}
+	stitches := make([]ast.IdentExpr, 0, 2)
+	for _, n := range names {
+		trimmed := strings.TrimSpace(n)
+		at := restAt
+		at.Column += len([]rune(n)) - len([]rune(strings.TrimLeftFunc(n, unicode.IsSpace)))
+		if !isChartStitch(trimmed) {
+			return symbol, entry, true, p.errorAt(at, "a symbol stands for one stitch, e.g. o = yo",
+				"Expected a stitch for %c, not '%s'", symbol, trimmed)
+		}
+		stitches = append(stitches, ast.IdentExpr{At: at, Name: trimmed})
+		restAt.Column += len([]rune(n)) + 1
+	}
+	entry.rs = stitches[0]
+	if len(stitches) > 1 {
+		entry.ws = stitches[1]
+	}
+	return symbol, entry, true, nil
+}
+
+func isChartStitch(name string) bool {
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// A symbol not in the legend may be the `symbol` of a stitch defined before
+// the chart, in its scope, one around it or an import
+func (p *Parser) chartStitch(cell chartCell, legend map[rune]legendEntry, rs bool) (ast.IdentExpr, bool) {
+	if entry, ok := legend[cell.symbol]; ok {
+		if rs {
+			return ast.IdentExpr{At: cell.at, Name: entry.rs.Name}, true
+		}
+		if entry.ws.Name == "" {
+			// Charts show the right side, a knit there is a purl from behind
+			return ast.IdentExpr{At: cell.at, Name: ast.WrongSideStitch(entry.rs.Name)}, true
+		}
+		return ast.IdentExpr{At: cell.at, Name: entry.ws.Name}, true
+	}
+	if name, ok := p.importer.scope.StitchBySymbol(string(cell.symbol)); ok {
+		return ast.IdentExpr{At: cell.at, Name: name}, true
+	}
+	return ast.IdentExpr{}, false
+}
+
+// Stitches taken off the needle by the named stitch, one if it isn't known
+func (p *Parser) chartConsumes(name string) int {
+	if def, ok := p.importer.scope.StitchDef(name); ok {
+		return def.Consumes
+	}
+	return 1
+}
+
+// Right side rows are read from right to left, wrong side rows from left to
+// right, the stitches between the repeat edges are repeated across the row
+func (p *Parser) chartRow(cells []chartCell, legend map[rune]legendEntry, rs bool) (*ast.RowExpr, error) {
+	_, edgeIsStitch := legend[CHART_REPEAT_EDGE]
+	if rs {
+		worked := make([]chartCell, len(cells))
+		for i, cell := range cells {
+			worked[len(cells)-1-i] = cell
+		}
+		cells = worked
+	}
+
+	before := make([]ast.IdentExpr, 0, len(cells))
+	var repeat, after []ast.IdentExpr
+	var edges []chartCell
+	for _, cell := range cells {
+		if cell.symbol == CHART_REPEAT_EDGE && !edgeIsStitch {
+			edges = append(edges, cell)
+			continue
+		}
+		stitch, ok := p.chartStitch(cell, legend, rs)
+		if !ok {
+			return nil, p.errorAt(cell.at, "give each symbol a stitch in the chart, e.g. o = yo",
+				"Symbol %c isn't in the legend", cell.symbol)
+		}
+		switch len(edges) {
+		case 0:
+			before = append(before, stitch)
+		case 1:
+			repeat = append(repeat, stitch)
+		default:
+			after = append(after, stitch)
+		}
+	}
+
+	switch {
+	case len(edges) == 1:
+		return nil, p.errorAt(edges[0].at, "a repeat is marked with a | either side of it",
+			"Repeat is only marked on one side")
+	case len(edges) > 2:
+		return nil, p.errorAt(edges[2].at, "a repeat is marked with a | either side of it",
+			"Row has %d repeat edges, a row can only have one repeat", len(edges))
+	case len(edges) == 2 && len(repeat) == 0:
+		return nil, p.errorAt(edges[0].at, "", "Repeat has no stitches")
+	case len(before)+len(repeat)+len(after) == 0:
+		return nil, p.errorAt(edges[0].at, "", "Row has no stitches")
+	}
+
+	stitches := chartRuns(before)
+	if len(repeat) > 0 {
+		var bound *ast.SizeExpr
+		if len(after) == 0 {
+			bound = ast.NewSizeExprAsterisk(NewTokenContainer(edges[1].at, ASTERISK_T, "*"))
+		} else {
+			n := 0
+			for _, stitch := range after {
+				n += p.chartConsumes(stitch.Name)
+			}
+			bound = chartCount(edges[1].at, n)
+			bound.Before = true
+		}
+		stitches = append(stitches, ast.NewRowExpr(chartRuns(repeat), ast.Brackets{Args: []ast.Expr{bound}}))
+		stitches = append(stitches, chartRuns(after)...)
+	}
+	return ast.NewRowExpr(stitches, ast.MakeBrackets()), nil
+}
+
+// The same stitch worked more than once in a row is counted, `k(3)`
+func chartRuns(stitches []ast.IdentExpr) []ast.Expr {
+	exprs := make([]ast.Expr, 0, len(stitches))
+	for i := 0; i < len(stitches); {
+		j := i + 1
+		for j < len(stitches) && stitches[j].Name == stitches[i].Name {
+			j++
+		}
+		args := ast.MakeBrackets()
+		if j-i > 1 {
+			args.Args = append(args.Args, chartCount(stitches[i].At, j-i))
+		}
+		exprs = append(exprs, ast.NewStitchExpr(stitches[i], args))
+		i = j
+	}
+	return exprs
+}
+
+func chartCount(at Position, n int) *ast.SizeExpr {
+	return ast.NewSizeExpr(int64(n), float64(n), NewTokenContainer(at, NUMERIC_T, strconv.Itoa(n)), ast.NOUNIT)
+}
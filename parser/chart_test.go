@@ -0,0 +1,150 @@
+package parser_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bodneyc/knit-and-go/lexer"
+	"github.com/bodneyc/knit-and-go/parser"
+)
+
+// The one error parsing `text`
+func parseError(t *testing.T, text string) *parser.Diagnostic {
+	t.Helper()
+	l, err := lexer.NewLexerFromString("test.knit", text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := parser.NewParser(*l)
+	var diags parser.Diagnostics
+	if !errors.As(p.Parse(), &diags) || len(diags) != 1 {
+		t.Fatalf("%q: want one diagnostic, got %v", text, diags)
+	}
+	return diags[0]
+}
+
+// Right side rows are read from right to left and wrong side rows from left
+// to right, in the round every row is a right side row
+func TestChartSides(t *testing.T) {
+	chart := "chart c {\n  . = k\n  o = yo\n  / = k2t\n  / o . .\n  . . o /\n}\nc\n"
+	cases := map[string][]string{
+		"co(4)\n" + chart:        {"co 4", "k2t yo k 2", "p2t yo p 2"},
+		"round\nco(4)\n" + chart: {"co 4", "k2t yo k 2", "k 2 yo k2t"},
+	}
+	for text, want := range cases {
+		rows, err := compile(t, text, "")
+		if err != nil {
+			t.Fatalf("%q: %v", text, err)
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Errorf("%q gives %q, want %q", text, rows, want)
+		}
+	}
+}
+
+// The chart in the README and the rows it says it's the same as
+func TestChartIsItsRows(t *testing.T) {
+	chart := "co(26)\nchart lace {\n  . = k, p\n  o = yo\n  / = k2t\n  \\ = ssk\n\n" +
+		"  .|. . . . . . . . . . . .|.\n" +
+		"  .|. . / o . . . . . o \\ .|.\n" +
+		"  .|. . . . . . . . . . . .|.\n" +
+		"  .|. o / . . . . . . \\ o .|.\n}\nlace\n"
+	written := "co(26)\nlace = {\n" +
+		"  k { k yo ssk k(6) k2t yo k }(-1) k\n" +
+		"  p { p(12) }(-1) p\n" +
+		"  k { k ssk yo k(5) yo k2t k(2) }(-1) k\n" +
+		"  p { p(12) }(-1) p\n}\nlace\n"
+	got, err := compile(t, chart, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := compile(t, written, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Chart gives %q, written out %q", got, want)
+	}
+}
+
+func TestChartRepeatEdges(t *testing.T) {
+	legend := "co(8)\nchart c {\n  . = k\n  o = yo\n  / = k2t\n"
+	cases := map[string]string{
+		"  |. o /|\n":          "{ k2t yo k } to end of row",
+		"  /|. o|. .\n":        "k 2 { yo k } until 2 k2t",
+		"  | = p\n  . | . |\n": "p k p k",
+	}
+	for row, want := range cases {
+		rows, err := compile(t, legend+row+"}\nc\n", "")
+		if err != nil {
+			t.Fatalf("%q: %v", row, err)
+		}
+		if got := rows[len(rows)-1]; got != want {
+			t.Errorf("%q gives %q, want %q", row, got, want)
+		}
+	}
+
+	errs := map[string]string{
+		"  . .|. .\n": "test.knit:3:6: Repeat is only marked on one side",
+		"  |.|.|.|\n": "test.knit:3:5: Row has 4 repeat edges, a row can only have one repeat",
+		"  .||.\n":    "test.knit:3:5: Repeat has no stitches",
+	}
+	for row, want := range errs {
+		if got := parseError(t, "chart c {\n  . = k\n"+row+"}\n").Error(); got != want {
+			t.Errorf("%q gives %q, want %q", row, got, want)
+		}
+	}
+}
+
+func TestChartUnknownSymbol(t *testing.T) {
+	d := parseError(t, "chart c {\n  . = k\n  . x .\n}\n")
+	want := lexer.Position{File: "test.knit", Line: 3, Column: 5}
+	if d.Pos != want || d.Msg != "Symbol x isn't in the legend" {
+		t.Errorf("Got %q at %s, want it at %s", d.Msg, d.Pos.Str(), want.Str())
+	}
+	// A stitch defined in braces is gone once they close
+	d = parseError(t, "body = {\n  stitch nupp { symbol \"n\" }\n}\nchart c {\n  . = k\n  . n .\n}\n")
+	if d.Msg != "Symbol n isn't in the legend" {
+		t.Errorf("Unexpected message %q", d.Msg)
+	}
+}
+
+// Symbols of stitches defined around the chart or imported are found as their
+// names are
+func TestChartSymbolsInScope(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knit-and-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "bobble.knit"),
+		[]byte("stitch bobble { symbol \"b\" }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "pattern.knit")
+	text := "import \"bobble.knit\"\nco(3)\nbody = {\n  stitch nupp { symbol \"n\" }\n" +
+		"  chart c {\n    . = k\n    n b .\n  }\n  c\n}\nbody\n"
+	if err := ioutil.WriteFile(file, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := lexer.NewLexerFromSources(lexer.FileSource(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := parser.NewParser(*l)
+	if err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := walk(t, p, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rows[len(rows)-1]; got != "k bobble nupp" {
+		t.Errorf("Chart row is %q, want %q", got, "k bobble nupp")
+	}
+}
@@ -0,0 +1,21 @@
+package parser
+
+import (
+	"github.com/bodneyc/knit-and-go/ast"
+)
+
+const (
+	FLAT_KEYWORD  = "flat"
+	ROUND_KEYWORD = "round"
+)
+
+// `flat` or `round` on its own, also kept by the parser until the end of the
+// braces it's in so charts after it are read the right way
+func (p *Parser) parseConstructionStmt(desc ast.CommentGroupExpr, ident ast.IdentExpr) ast.Stmt {
+	in := ast.FLAT
+	if ident.Name == ROUND_KEYWORD {
+		in = ast.ROUND
+	}
+	p.construction = in
+	return ast.NewConstructionStmt(desc, ident.Pos(), in)
+}
@@ -0,0 +1,213 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	. "github.com/bodneyc/knit-and-go/lexer"
+	. "github.com/bodneyc/knit-and-go/util"
+)
+
+// ------------------ Diagnostic ------------------
+
+// A mistake in the pattern as its author would want it, where and what, and
+// perhaps how to fix it
+type Diagnostic struct {
+	Pos  Position `json:"pos"`
+	Msg  string   `json:"message"`
+	Hint string   `json:"hint"`
+	// "error" if not given
+	Severity string `json:"severity"`
+	trace    string
+}
+
+func (d *Diagnostic) Error() string {
+	if !d.placed() {
+		return d.Msg
+	}
+	return fmt.Sprintf("%s: %s", d.location(), d.Msg)
+}
+
+// Where in the parser the error came from, only of use to us
+func (d *Diagnostic) StackTrace() string {
+	return d.trace
+}
+
+// Some errors are of the pattern as a whole, or the options it's run with
+func (d *Diagnostic) placed() bool {
+	return d.Pos.Line > 0
+}
+
+func (d *Diagnostic) location() string {
+	if d.Pos.File == "" {
+		return fmt.Sprintf("<input>:%s", d.Pos.Str())
+	}
+	return d.Pos.Str()
+}
+
+// Compiler style, with the offending line of `source` and a caret under the
+// column
+func (d *Diagnostic) Format(source []string) string {
+	var b strings.Builder
+	severity := d.Severity
+	if severity == "" {
+		severity = "error"
+	}
+	if d.placed() {
+		fmt.Fprintf(&b, "%s: ", d.location())
+	}
+	fmt.Fprintf(&b, "%s: %s\n", severity, d.Msg)
+	if d.Pos.Line >= 1 && d.Pos.Line <= len(source) {
+		line := strings.TrimRight(source[d.Pos.Line-1], "\r")
+		gutter := fmt.Sprintf("%5d", d.Pos.Line)
+		fmt.Fprintf(&b, "%s | %s\n", gutter, line)
+
+		// Tabs are kept so the caret lines up however they're shown
+		var pad strings.Builder
+		for i, r := range []rune(line) {
+			if i >= d.Pos.Column-1 {
+				break
+			}
+			if r == '\t' {
+				pad.WriteRune('\t')
+			} else {
+				pad.WriteRune(' ')
+			}
+		}
+		fmt.Fprintf(&b, "%s | %s^\n", strings.Repeat(" ", len(gutter)), pad.String())
+	}
+	if d.Hint != "" {
+		fmt.Fprintf(&b, "%s = hint: %s\n", strings.Repeat(" ", 5), d.Hint)
+	}
+	return b.String()
+}
+
+type Diagnostics []*Diagnostic
+
+func (d Diagnostics) Error() string {
+	s := make([]string, len(d))
+	for i, diag := range d {
+		s[i] = diag.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+// Each file is only read once, a file that can't be read just loses its
+// snippets
+func (d Diagnostics) Write(w io.Writer) {
+	d.WriteWithTexts(w, nil)
+}
+
+// Texts stand in for inputs that can't be read again, such as stdin
+func (d Diagnostics) WriteWithTexts(w io.Writer, texts map[string]string) {
+	sources := make(map[string][]string)
+	for _, diag := range d {
+		source, ok := sources[diag.Pos.File]
+		if !ok {
+			if text, ok := texts[diag.Pos.File]; ok {
+				source = strings.Split(text, "\n")
+			} else if b, err := ioutil.ReadFile(diag.Pos.File); err == nil {
+				source = strings.Split(string(b), "\n")
+			}
+			sources[diag.Pos.File] = source
+		}
+		fmt.Fprintln(w, diag.Format(source))
+	}
+}
+
+// ------------------ Parser ------------------
+
+func describe(t TokenContainer) string {
+	switch t.Tok {
+	case NEW_LINE_T:
+		return "end of line"
+	case EOF_T:
+		return "end of input"
+	case NEXT_SOURCE_T:
+		return "end of file"
+	}
+	return fmt.Sprintf("'%s'", t.Str)
+}
+
+func (p *Parser) errorAt(pos Position, hint string, format string, a ...interface{}) error {
+	return &Diagnostic{
+		Pos:   pos,
+		Msg:   fmt.Sprintf(format, a...),
+		Hint:  hint,
+		trace: StackLineN(2),
+	}
+}
+
+// Errors from the parser as diagnostics, those which aren't already are placed
+// at the last token read
+func (p *Parser) diagnose(err error) Diagnostics {
+	return diagnoseAt(err, p.last.Pos)
+}
+
+// Errors from the walk for lines as diagnostics, those without a position are
+// of the pattern as a whole
+func Diagnose(err error) Diagnostics {
+	return diagnoseAt(err, NO_POS)
+}
+
+func diagnoseAt(err error, at Position) Diagnostics {
+	var diags Diagnostics
+	if errors.As(err, &diags) {
+		return diags
+	}
+
+	var diag *Diagnostic
+	if errors.As(err, &diag) {
+		found := *diag
+		found.trace = passedUp(err, diag, diag.trace)
+		return Diagnostics{&found}
+	}
+
+	var posErr *PosError
+	if errors.As(err, &posErr) {
+		return Diagnostics{&Diagnostic{
+			Pos:   posErr.At,
+			Msg:   posErr.Msg,
+			Hint:  posErr.Hint,
+			trace: passedUp(err, posErr, posErr.StackTrace()),
+		}}
+	}
+
+	// Anything else is only wrapped with where it was passed up from
+	root := err
+	for errors.Unwrap(root) != nil {
+		root = errors.Unwrap(root)
+	}
+	return Diagnostics{&Diagnostic{
+		Pos:   at,
+		Msg:   root.Error(),
+		trace: passedUp(err, root, ""),
+	}}
+}
+
+// The trace of the error `found` in `err`, followed by the stack lines `err`
+// gained on the way up wrapping it
+func passedUp(err error, found error, trace string) string {
+	return trace + strings.TrimPrefix(err.Error(), found.Error())
+}
+
+// Skips what's left of the line an error was found on so parsing can carry on
+// from the next, the end of the input is left for the caller
+func (p *Parser) skipLine() {
+	if p.last.Tok == NEW_LINE_T {
+		return
+	}
+	for {
+		switch p.lexer.Peek().Tok {
+		case EOF_T, NEXT_SOURCE_T:
+			return
+		case NEW_LINE_T:
+			p.next()
+			return
+		}
+		p.next()
+	}
+}
@@ -0,0 +1,101 @@
+package parser_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bodneyc/knit-and-go/ast"
+	"github.com/bodneyc/knit-and-go/lexer"
+	"github.com/bodneyc/knit-and-go/parser"
+)
+
+// Parsing carries on from the next line after an error, in braces and a
+// chart too, so every error is given at once
+func TestParseCollectsEveryError(t *testing.T) {
+	text := "co(4)\nimport \"\"\nk(2, depth=0)\nyolk = {\n  p(depth=x)\n  k\n}\n" +
+		"chart c {\n  . = k\n  . x\n}\nstitch s { lean up }\n"
+	l, err := lexer.NewLexerFromString("test.knit", text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := parser.NewParser(*l)
+
+	var diags parser.Diagnostics
+	if !errors.As(p.Parse(), &diags) {
+		t.Fatal("Parsed without errors")
+	}
+	want := []string{
+		"test.knit:2:8: Empty import path",
+		"test.knit:3:12: Expected a depth of 1 or more, not '0'",
+		"test.knit:5:11: Expected a depth of 1 or more, not 'x'",
+		"test.knit:10:5: Symbol x isn't in the legend",
+		"test.knit:12:17: lean must be left, right or none, not 'up'",
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("Want %d diagnostics, got %d: %v", len(want), len(diags), diags)
+	}
+	for i, d := range diags {
+		if got := d.Error(); got != want[i] {
+			t.Errorf("Diagnostic %d is %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+// Errors from the walk are placed where they're found, whatever the file is
+// called
+func TestWalkDiagnosticsArePlaced(t *testing.T) {
+	l, err := lexer.NewLexerFromString("my pattern.knit", "sizes S M\nco(4)\nk(1/2/3)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := parser.NewParser(*l)
+	if err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	e := ast.NewEngineData()
+	p.WalkForLocals(e)
+
+	var diags parser.Diagnostics
+	if !errors.As(p.WalkForLines(e), &diags) || len(diags) != 1 {
+		t.Fatalf("Want one diagnostic, got %v", diags)
+	}
+	d := diags[0]
+	want := lexer.Position{File: "my pattern.knit", Line: 3, Column: 4}
+	if d.Pos != want {
+		t.Errorf("Placed at %s, want %s", d.Pos.Str(), want.Str())
+	}
+	if d.Msg != "1 / 2 does not divide evenly, leaving 1" {
+		t.Errorf("Unexpected message %q", d.Msg)
+	}
+	if d.Hint != "for a value for each of S, M separate them with |" {
+		t.Errorf("Unexpected hint %q", d.Hint)
+	}
+}
+
+// Where in the code an error came from is kept apart from what it says
+func TestWalkDiagnosticsKeepTheirTrace(t *testing.T) {
+	l, err := lexer.NewLexerFromString("test.knit", "co(4)\nk(4)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := parser.NewParser(*l)
+	if err := p.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	e := ast.NewEngineData()
+	e.SelectSize("M")
+	p.WalkForLocals(e)
+
+	var diags parser.Diagnostics
+	if !errors.As(p.WalkForLines(e), &diags) || len(diags) != 1 {
+		t.Fatalf("Want one diagnostic, got %v", diags)
+	}
+	d := diags[0]
+	if d.Msg != "Size M given but the pattern doesn't declare any sizes" {
+		t.Errorf("Unexpected message %q", d.Msg)
+	}
+	if !strings.Contains(d.StackTrace(), ".go") {
+		t.Errorf("No stack line in the trace %q", d.StackTrace())
+	}
+}
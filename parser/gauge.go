@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bodneyc/knit-and-go/ast"
+	. "github.com/bodneyc/knit-and-go/lexer"
+	. "github.com/bodneyc/knit-and-go/util"
+)
+
+const GAUGE_KEYWORD = "gauge"
+
+// `gauge` already consumed and followed by '(', the counts are marked by `sts`
+// or `rows` and the length by its unit, in any order
+func (p *Parser) parseGaugeStmt(desc ast.CommentGroupExpr, ident ast.IdentExpr) (ast.Stmt, error) {
+	p.nextIgnoreWs() // Consume '('
+	var stitches, rows float64
+	var over *ast.SizeExpr
+	for {
+		t, err := p.nextIgnoreWs()
+		if err != nil {
+			return nil, fmt.Errorf("Unclosed gauge: %w%s", err, StackLine())
+		}
+
+		switch t.Tok {
+		case RIGHT_PAREN_T:
+			if over == nil {
+				return nil, p.errorAt(ident.Pos(), "", "Gauge needs a length, e.g. 10cm")
+			}
+			if stitches == 0 && rows == 0 {
+				return nil, p.errorAt(ident.Pos(), "", "Gauge needs stitches or rows")
+			}
+			return ast.NewGaugeStmt(desc, ident.Pos(), stitches, rows, over), nil
+
+		case COMMA_T:
+			continue
+
+		case NUMERIC_T:
+			n, err := strconv.ParseFloat(t.Str, 64)
+			if err != nil || n <= 0 {
+				return nil, p.errorAt(t.Pos, "", "Invalid gauge count %s", t.Str)
+			}
+			tp := p.peekIgnoreWs()
+			switch strings.ToLower(tp.Str) {
+			case "st", "sts", "stitch", "stitches":
+				p.nextIgnoreWs()
+				stitches = n
+			case "row", "rows":
+				p.nextIgnoreWs()
+				rows = n
+			default:
+				size, err := p.parseSizeExpr(t)
+				if err != nil {
+					return nil, fmt.Errorf("%w%s", err, StackLine())
+				}
+				if size.Unit == ast.NOUNIT {
+					return nil, p.errorAt(t.Pos, "", "Gauge %s needs sts, rows or a unit", t.Str)
+				}
+				over = size
+			}
+
+		default:
+			return nil, p.errorAt(t.Pos, "", "Unexpected %s in gauge", describe(t))
+		}
+	}
+}
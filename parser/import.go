@@ -0,0 +1,203 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bodneyc/knit-and-go/ast"
+	. "github.com/bodneyc/knit-and-go/lexer"
+	. "github.com/bodneyc/knit-and-go/util"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const IMPORT_KEYWORD = "import"
+
+// Shared between a parser and the parsers of everything it imports; files
+// are included once in each scope, `included` holds those of the scopes open,
+// outermost first, and `scope` the stitches defined in them so far. The number of sizes declared so far, in any file, is how
+// many plain numbers between `/` are ambiguous, those `slashed` before any
+// are held until they're declared
+type importer struct {
+	paths    []string
+	included []map[string]bool
+	scope    *ast.Scope
+	chain    []string
+	sizes    int
+	slashed  [][]*ast.SizeExpr
+}
+
+func newImporter() *importer {
+	return &importer{
+		paths:    make([]string, 0),
+		included: []map[string]bool{make(map[string]bool)},
+		scope:    ast.NewScope(nil),
+		chain:    make([]string, 0),
+	}
+}
+
+// The braces of a group or assignment are a scope of their own
+func (i *importer) pushScope() {
+	i.included = append(i.included, make(map[string]bool))
+	i.scope = ast.NewScope(i.scope)
+}
+
+func (i *importer) popScope() {
+	i.included = i.included[:len(i.included)-1]
+	i.scope = i.scope.Parent()
+}
+
+// Declarations of a file included in the scope or one around it are already
+// visible
+func (i *importer) visible(file string) bool {
+	for _, scope := range i.included {
+		if scope[file] {
+			return true
+		}
+	}
+	return false
+}
+
+func displayPath(path string) string {
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil {
+			return rel
+		}
+	}
+	return path
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+func (i *importer) enter(file string) {
+	if file == "" {
+		return
+	}
+	file = absPath(file)
+	i.included[len(i.included)-1][file] = true
+	i.chain = append(i.chain, file)
+}
+
+func (i *importer) leave() {
+	if len(i.chain) > 0 {
+		i.chain = i.chain[:len(i.chain)-1]
+	}
+}
+
+// Relative to the importing file first, then each search path in order
+func (i *importer) resolve(from string, path string, at Position) (string, error) {
+	if filepath.IsAbs(path) {
+		if _, err := os.Stat(path); err != nil {
+			return "", importErrorAt(at, "Could not find import %s", path)
+		}
+		return filepath.Clean(path), nil
+	}
+
+	dirs := make([]string, 0, len(i.paths)+1)
+	if from != "" {
+		dirs = append(dirs, filepath.Dir(from))
+	} else {
+		dirs = append(dirs, ".")
+	}
+	dirs = append(dirs, i.paths...)
+
+	for _, dir := range dirs {
+		candidate := filepath.Join(dir, path)
+		if stat, err := os.Stat(candidate); err == nil && !stat.IsDir() {
+			return absPath(candidate), nil
+		}
+	}
+	return "", importErrorAt(at, "Could not find import \"%s\" in: %s", path, strings.Join(dirs, ", "))
+}
+
+// The importer has no parser of its own to place errors with
+func importErrorAt(at Position, format string, a ...interface{}) error {
+	return &Diagnostic{
+		Pos:   at,
+		Msg:   fmt.Sprintf(format, a...),
+		trace: StackLineN(2),
+	}
+}
+
+func (i *importer) cycle(file string) []string {
+	for idx, f := range i.chain {
+		if f == file {
+			cycle := make([]string, 0, len(i.chain)-idx+1)
+			for _, c := range i.chain[idx:] {
+				cycle = append(cycle, displayPath(c))
+			}
+			return append(cycle, displayPath(file))
+		}
+	}
+	return nil
+}
+
+func (i *importer) include(from string, stmt *ast.ImportStmt) error {
+	file, err := i.resolve(from, stmt.Path, stmt.At)
+	if err != nil {
+		return err
+	}
+	stmt.File = file
+
+	if cycle := i.cycle(file); cycle != nil {
+		return importErrorAt(stmt.At, "Import cycle: %s", strings.Join(cycle, " -> "))
+	}
+
+	if i.visible(file) {
+		log.WithField("import", displayPath(file)).Debug("Already included, skipping")
+		return nil
+	}
+	i.included[len(i.included)-1][file] = true
+
+	log.WithField("import", displayPath(file)).Info("Parsing import")
+
+	l, err := NewLexerFromSources(FileSource(displayPath(file)))
+	if err != nil {
+		return importErrorAt(stmt.At, "%v", err)
+	}
+	child := NewParser(*l)
+	child.importer = i
+	if err := child.Parse(); err != nil {
+		return fmt.Errorf("Error parsing import \"%s\": %w%s", stmt.Path, err, StackLine())
+	}
+	stmt.Block = &child.Root
+
+	return nil
+}
+
+func (p *Parser) AddImportPaths(paths ...string) {
+	p.importer.paths = append(p.importer.paths, paths...)
+}
+
+// `import` identifier already consumed and followed by '"'
+func (p *Parser) parseImport(desc ast.CommentGroupExpr, ident ast.IdentExpr) (ast.Stmt, error) {
+	if _, err := p.nextIgnoreWs(); err != nil { // Consume '"'
+		return nil, fmt.Errorf("%w%s", err, StackLine())
+	}
+	t, err := p.lexer.NextQuoted()
+	if err != nil {
+		return nil, fmt.Errorf("%w%s", err, StackLine())
+	}
+	if t.Str == "" {
+		return nil, p.errorAt(t.Pos, "", "Empty import path")
+	}
+
+	switch tp := p.peekIgnoreWs(); tp.Tok {
+	case NEW_LINE_T, COMMENT_T, EOF_T, NEXT_SOURCE_T:
+	default:
+		return nil, p.errorAt(tp.Pos, "", "Unexpected %s following the import of %s", describe(tp), t.Str)
+	}
+
+	stmt := ast.NewImportStmt(desc, ident.Pos(), t.Str)
+	if err := p.importer.include(p.lexer.CurrentFile(), stmt); err != nil {
+		return nil, fmt.Errorf("%w%s", err, StackLine())
+	}
+	return stmt, nil
+}
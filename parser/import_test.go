@@ -0,0 +1,124 @@
+package parser_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bodneyc/knit-and-go/ast"
+	"github.com/bodneyc/knit-and-go/lexer"
+	"github.com/bodneyc/knit-and-go/parser"
+)
+
+// A directory of patterns, by their path within it, removed by the function
+// returned
+func writePatterns(t *testing.T, files map[string]string) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "knit-and-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, text := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func parseFile(file string, paths ...string) (*parser.Parser, error) {
+	l, err := lexer.NewLexerFromSources(lexer.FileSource(file))
+	if err != nil {
+		return nil, err
+	}
+	p := parser.NewParser(*l)
+	p.AddImportPaths(paths...)
+	return p, p.Parse()
+}
+
+// Files are shown relative to where `knit` is run
+func shown(t *testing.T, path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rel
+}
+
+// Relative to the importing file first, then each search path in order, an
+// import within an import is relative to the file it's in
+func TestImportResolution(t *testing.T) {
+	dir, remove := writePatterns(t, map[string]string{
+		"sub/pattern.knit":  "import \"stitches.knit\"\nimport \"lib-only.knit\"\nimport \"../shared.knit\"\n",
+		"sub/stitches.knit": "",
+		"lib/stitches.knit": "",
+		"lib/lib-only.knit": "import \"helper.knit\"\n",
+		"lib/helper.knit":   "",
+		"more/helper.knit":  "",
+		"shared.knit":       "",
+	})
+	defer remove()
+
+	p, err := parseFile(filepath.Join(dir, "sub/pattern.knit"), filepath.Join(dir, "lib"), filepath.Join(dir, "more"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"sub/stitches.knit", "lib/lib-only.knit", "shared.knit"}
+	for i, stmt := range p.Root.Block {
+		imp := stmt.(*ast.ImportStmt)
+		if imp.File != filepath.Join(dir, want[i]) {
+			t.Errorf("%s found at %s, want %s", imp.Path, imp.File, want[i])
+		}
+	}
+	helper := p.Root.Block[1].(*ast.ImportStmt).Block.Block[0].(*ast.ImportStmt)
+	if helper.File != filepath.Join(dir, "lib/helper.knit") {
+		t.Errorf("%s found at %s, want lib/helper.knit", helper.Path, helper.File)
+	}
+}
+
+func TestImportNotFound(t *testing.T) {
+	dir, remove := writePatterns(t, map[string]string{"pattern.knit": "co(4)\nimport \"nope.knit\"\n"})
+	defer remove()
+
+	_, err := parseFile(filepath.Join(dir, "pattern.knit"), filepath.Join(dir, "lib"))
+	var diags parser.Diagnostics
+	if !errors.As(err, &diags) || len(diags) != 1 {
+		t.Fatalf("Want one diagnostic, got %v", err)
+	}
+	want := "Could not find import \"nope.knit\" in: " + dir + ", " + filepath.Join(dir, "lib")
+	if d := diags[0]; d.Msg != want || d.Pos.Line != 2 || d.Pos.Column != 1 {
+		t.Errorf("Got %q at %s, want %q at 2:1", d.Msg, d.Pos.Str(), want)
+	}
+}
+
+// The cycle is given from the file it starts and ends at, placed at the
+// import that closes it
+func TestImportCycle(t *testing.T) {
+	dir, remove := writePatterns(t, map[string]string{
+		"a.knit": "import \"b.knit\"\n",
+		"b.knit": "co(4)\nimport \"c.knit\"\n",
+		"c.knit": "import \"b.knit\"\n",
+	})
+	defer remove()
+
+	_, err := parseFile(filepath.Join(dir, "a.knit"))
+	var diags parser.Diagnostics
+	if !errors.As(err, &diags) || len(diags) != 1 {
+		t.Fatalf("Want one diagnostic, got %v", err)
+	}
+	b, c := shown(t, filepath.Join(dir, "b.knit")), shown(t, filepath.Join(dir, "c.knit"))
+	want := "Import cycle: " + b + " -> " + c + " -> " + b
+	if d := diags[0]; d.Msg != want || d.Pos.File != c || d.Pos.Line != 1 {
+		t.Errorf("Got %q at %s, want %q in %s", d.Msg, d.Pos.Str(), want, c)
+	}
+}
@@ -1,10 +1,7 @@
 package parser
 
 import (
-	"fmt"
-
 	. "github.com/bodneyc/knit-and-go/lexer"
-	. "github.com/bodneyc/knit-and-go/util"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -12,6 +9,7 @@ import (
 func (p *Parser) next() TokenContainer {
 	t := p.lexer.Next()
 	log.WithFields(t.Fields()).Trace("[Parser.next]")
+	p.last = t
 	return t
 }
 
@@ -19,8 +17,9 @@ func (p *Parser) nextIgnoreWsCr() (TokenContainer, error) {
 	for {
 		t := p.lexer.Next()
 		log.WithFields(t.Fields()).Trace("[Parser.nextIgnoreWsCr]")
+		p.last = t
 		if t.Tok == EOF_T {
-			return t, fmt.Errorf(":EOF: before next token%s", StackLine())
+			return t, p.errorAt(t.Pos, "", "Unexpected end of input")
 		}
 		if t.Tok != WHITE_SPACE_T && t.Tok != NEW_LINE_T {
 			return t, nil
@@ -32,8 +31,9 @@ func (p *Parser) nextIgnoreWs() (TokenContainer, error) {
 	for {
 		t := p.lexer.Next()
 		log.WithFields(t.Fields()).Trace("[Parser.nextIgnoreWs]")
+		p.last = t
 		if t.Tok == EOF_T {
-			return t, fmt.Errorf(":EOF: before next token%s", StackLine())
+			return t, p.errorAt(t.Pos, "", "Unexpected end of input")
 		}
 		if t.Tok != WHITE_SPACE_T {
 			return t, nil
@@ -0,0 +1,182 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bodneyc/knit-and-go/ast"
+	. "github.com/bodneyc/knit-and-go/lexer"
+	. "github.com/bodneyc/knit-and-go/util"
+)
+
+func isArithOp(tok Token) bool {
+	switch tok {
+	case PLUS_T, MINUS_T, ASTERISK_T, SLASH_T:
+		return true
+	}
+	return false
+}
+
+// A number as it's written, `96`, rather than in brackets or by name; `t` is
+// the first token of `x`
+func isPlainNumber(t TokenContainer, x ast.Expr) bool {
+	size, ok := x.(*ast.SizeExpr)
+	return t.Tok == NUMERIC_T && ok && size.Unit == ast.NOUNIT && !size.Before && size.Ni >= 0
+}
+
+// Plain numbers between `/` are divided, `96/2`, but as many of them as the
+// pattern has sizes could as well be a value for each, `88/96`, so that's an
+// error; those before `sizes` are checked once it's declared
+func (p *Parser) divide(values []*ast.SizeExpr, slashes []TokenContainer) (ast.Expr, error) {
+	if len(values) == p.importer.sizes {
+		return nil, slashedErr(values)
+	}
+	if p.importer.sizes == 0 {
+		p.importer.slashed = append(p.importer.slashed, values)
+	}
+	var x ast.Expr = values[0]
+	for i, slash := range slashes {
+		x = ast.NewArithExpr(slash, x, values[i+1])
+	}
+	return x, nil
+}
+
+func slashedErr(values []*ast.SizeExpr) *Diagnostic {
+	texts := make([]string, len(values))
+	for i, value := range values {
+		texts[i] = strconv.FormatInt(value.Ni, 10)
+	}
+	slashed := strings.Join(texts, "/")
+	return &Diagnostic{
+		Pos: values[0].Pos(),
+		Msg: fmt.Sprintf("%s could be a value for each size or a division", slashed),
+		Hint: fmt.Sprintf("give %s for a value for each size, or (%s)/%s to divide",
+			strings.Join(texts, " | "), texts[0], strings.Join(texts[1:], "/")),
+		trace: StackLine(),
+	}
+}
+
+// A sum of products, `t` is the first token of it
+func (p *Parser) parseArithExpr(t TokenContainer) (ast.Expr, error) {
+	x, err := p.parseArithTerm(t)
+	if err != nil {
+		return nil, fmt.Errorf("%w%s", err, StackLine())
+	}
+	for {
+		op := p.peekIgnoreWs()
+		if op.Tok != PLUS_T && op.Tok != MINUS_T {
+			return x, nil
+		}
+		p.nextIgnoreWs() // Consume operator
+		t, err := p.nextIgnoreWs()
+		if err != nil {
+			return nil, fmt.Errorf("%w%s", err, StackLine())
+		}
+		y, err := p.parseArithTerm(t)
+		if err != nil {
+			return nil, fmt.Errorf("%w%s", err, StackLine())
+		}
+		x = ast.NewArithExpr(op, x, y)
+	}
+}
+
+func (p *Parser) parseArithTerm(t TokenContainer) (ast.Expr, error) {
+	x, err := p.parseArithFactor(t)
+	if err != nil {
+		return nil, fmt.Errorf("%w%s", err, StackLine())
+	}
+	// A run of plain numbers between `/` is only settled once it ends, a
+	// number in brackets, `(96)/2`, is always divided
+	plain := isPlainNumber(t, x)
+	var values []*ast.SizeExpr
+	var slashes []TokenContainer
+	for {
+		op := p.peekIgnoreWs()
+		if op.Tok != ASTERISK_T && op.Tok != SLASH_T {
+			break
+		}
+		p.nextIgnoreWs() // Consume operator
+		t, err := p.nextIgnoreWs()
+		if err != nil {
+			return nil, fmt.Errorf("%w%s", err, StackLine())
+		}
+		y, err := p.parseArithFactor(t)
+		if err != nil {
+			return nil, fmt.Errorf("%w%s", err, StackLine())
+		}
+		if op.Tok == SLASH_T && plain && isPlainNumber(t, y) {
+			if len(values) == 0 {
+				values = append(values, x.(*ast.SizeExpr))
+			}
+			values, slashes = append(values, y.(*ast.SizeExpr)), append(slashes, op)
+			continue
+		}
+		if len(values) > 0 {
+			if x, err = p.divide(values, slashes); err != nil {
+				return nil, err
+			}
+			values, slashes = nil, nil
+		}
+		x, plain = ast.NewArithExpr(op, x, y), false
+	}
+	if len(values) > 0 {
+		return p.divide(values, slashes)
+	}
+	return x, nil
+}
+
+func (p *Parser) parseArithFactor(t TokenContainer) (ast.Expr, error) {
+	switch t.Tok {
+	case NUMERIC_T:
+		size, err := p.parseSizeExpr(t)
+		if err != nil {
+			return nil, fmt.Errorf("%w%s", err, StackLine())
+		}
+		if p.peekIgnoreWs().Tok == PIPE_T {
+			variant, err := p.parseVariantExpr(size)
+			if err != nil {
+				return nil, fmt.Errorf("%w%s", err, StackLine())
+			}
+			return variant, nil
+		}
+		return size, nil
+
+	case IDENTIFIER_T:
+		return ast.NewIdentExpr(t), nil
+
+	case LEFT_PAREN_T:
+		t, err := p.nextIgnoreWs()
+		if err != nil {
+			return nil, fmt.Errorf("%w%s", err, StackLine())
+		}
+		x, err := p.parseArithExpr(t)
+		if err != nil {
+			return nil, fmt.Errorf("%w%s", err, StackLine())
+		}
+		if t, _ := p.nextIgnoreWs(); t.Tok != RIGHT_PAREN_T {
+			return nil, p.errorAt(t.Pos, "", "Expected closing bracket, not %s", describe(t))
+		}
+		return x, nil
+
+	default:
+		return nil, p.errorAt(t.Pos, "", "Expected a number, not %s", describe(t))
+	}
+}
+
+// `ident =` already consumed, numbers can't take parameters
+func (p *Parser) parseNumberStmt(desc ast.CommentGroupExpr, ident ast.IdentExpr, params []ast.IdentExpr, t TokenContainer) (ast.Stmt, error) {
+	if len(params) > 0 {
+		return nil, p.errorAt(ident.Pos(), "", "The number %s can't take parameters", ident.Name)
+	}
+	rhs, err := p.parseArithExpr(t)
+	if err != nil {
+		return nil, fmt.Errorf("%w%s", err, StackLine())
+	}
+	switch tp := p.peekIgnoreWs(); tp.Tok {
+	case NEW_LINE_T, COMMENT_T, EOF_T, NEXT_SOURCE_T:
+		return ast.NewNumberStmt(desc, ident, rhs), nil
+	default:
+		return nil, p.errorAt(tp.Pos, "", "Unexpected %s following the number %s", describe(tp), ident.Name)
+	}
+}
@@ -0,0 +1,185 @@
+package parser_test
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bodneyc/knit-and-go/ast"
+	"github.com/bodneyc/knit-and-go/lexer"
+	"github.com/bodneyc/knit-and-go/parser"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.ErrorLevel)
+	os.Exit(m.Run())
+}
+
+func parse(t *testing.T, text string) *parser.Parser {
+	t.Helper()
+	l, err := lexer.NewLexerFromString("test.knit", text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := parser.NewParser(*l)
+	if err := p.Parse(); err != nil {
+		t.Fatalf("%q doesn't parse: %v", text, err)
+	}
+	return p
+}
+
+// The rows worked for `size`, as they're shown, or the error compiling them
+func compile(t *testing.T, text string, size string) ([]string, error) {
+	t.Helper()
+	return walk(t, parse(t, text), size)
+}
+
+func walk(t *testing.T, p *parser.Parser, size string) ([]string, error) {
+	t.Helper()
+	e := ast.NewEngineData()
+	e.SelectSize(size)
+	p.WalkForLocals(e)
+	if err := p.WalkForLines(e); err != nil {
+		return nil, err
+	}
+	rows := make([]string, 0)
+	for _, in := range e.Instrs {
+		if in.Kind == ast.ROW_IK {
+			texts := make([]string, len(in.Lc.Phrases))
+			for i, p := range in.Lc.Phrases {
+				texts[i] = p.Text
+			}
+			rows = append(rows, strings.Join(texts, " "))
+		}
+	}
+	return rows, nil
+}
+
+// The last statement, a row of one stitch or a number
+func lastValue(t *testing.T, p *parser.Parser) ast.Expr {
+	t.Helper()
+	switch s := p.Root.Block[len(p.Root.Block)-1].(type) {
+	case *ast.RowStmt:
+		return s.Row.Stitches[0].(*ast.StitchExpr).Args.Args[0]
+	case *ast.NumberStmt:
+		return s.Rhs
+	default:
+		t.Fatalf("Unexpected statement %#v", s)
+		return nil
+	}
+}
+
+func TestSlashBetweenPlainNumbers(t *testing.T) {
+	variants := map[string]int{
+		"co(88 | 96)":                       2,
+		"sizes S M L\nco(88 | 96 | 104)":    3,
+		"sizes S M L\nn = 88 | 96 | 104":    3,
+		"sizes S M L XL\nco(88 | 96 | 104)": 3,
+		"sizes S M\nk(2cm | 3cm)":           2,
+	}
+	for text, n := range variants {
+		v, ok := lastValue(t, parse(t, text)).(*ast.VariantExpr)
+		if !ok {
+			t.Errorf("%q isn't a value for each size", text)
+			continue
+		}
+		if len(v.Values) != n {
+			t.Errorf("%q has %d values, want %d", text, len(v.Values), n)
+		}
+	}
+
+	divisions := []string{
+		"co(96/2)",
+		"n = 96/2",
+		"co(88/96/104)",
+		"sizes S M L\nco(96/2)",
+		"sizes S M L XL\nco(88/96/104)",
+		"sizes S M\nco((96)/2)",
+		"sizes S M\nn = (96)/2",
+		"sts = 96\nn = sts / 2",
+		"sts = 96\nk(sts/4 - 2)",
+		"k(96/(2))",
+		"sizes S M\nk((88 | 96) / 2)",
+		"k(2 * 96/3)",
+	}
+	for _, text := range divisions {
+		if _, ok := lastValue(t, parse(t, text)).(*ast.ArithExpr); !ok {
+			t.Errorf("%q isn't worked out", text)
+		}
+	}
+}
+
+// As many plain numbers between `/` as there are sizes could be either, wherever
+// the sizes are declared
+func TestSlashAsManyAsSizes(t *testing.T) {
+	cases := map[string]string{
+		"sizes S M\nco(96/2)":               "test.knit:2:4: 96/2 could be a value for each size or a division",
+		"sizes S M L\nn = 88/96/104":        "test.knit:2:5: 88/96/104 could be a value for each size or a division",
+		"co(96/2)\nsizes S M\n":             "test.knit:1:4: 96/2 could be a value for each size or a division",
+		"sizes S M\nk(1 + 96/2 * 3)\n":      "test.knit:2:7: 96/2 could be a value for each size or a division",
+		"sizes S M L\nk(96/2) k(88/96/104)": "test.knit:2:11: 88/96/104 could be a value for each size or a division",
+	}
+	for text, want := range cases {
+		l, err := lexer.NewLexerFromString("test.knit", text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := parser.NewParser(*l)
+		var diags parser.Diagnostics
+		if !errors.As(p.Parse(), &diags) || len(diags) != 1 {
+			t.Errorf("%q gave %v, want one error", text, diags)
+			continue
+		}
+		if got := diags[0].Error(); got != want {
+			t.Errorf("%q gave %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestSlashAsManyAsSizesHint(t *testing.T) {
+	l, err := lexer.NewLexerFromString("test.knit", "sizes S M L\nco(88/96/104)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var diags parser.Diagnostics
+	if !errors.As(parser.NewParser(*l).Parse(), &diags) {
+		t.Fatal("88/96/104 for three sizes parses")
+	}
+	if want := "give 88 | 96 | 104 for a value for each size, or (88)/96/104 to divide"; diags[0].Hint != want {
+		t.Errorf("Hint is %q, want %q", diags[0].Hint, want)
+	}
+}
+
+func TestSizeVariantsCompile(t *testing.T) {
+	cases := []struct {
+		text, size string
+		rows       []string
+		err        string
+	}{
+		{text: "sizes S M\nco(88 | 96)", size: "M", rows: []string{"co 96"}},
+		{text: "sizes S M\nco((96)/2)", size: "M", rows: []string{"co 48"}},
+		{text: "co(96/2)", rows: []string{"co 48"}},
+		{text: "co((96)/2)", rows: []string{"co 48"}},
+		{text: "sizes S M L\nco(96/2)", size: "L", rows: []string{"co 48"}},
+		{text: "sizes S M\nco((88 | 96)/2)", size: "S", rows: []string{"co 44"}},
+		{text: "sizes S M L XL\ncast-on(88/96/104)", err: "test.knit:2:11: 88 / 96 does not divide evenly"},
+		{text: "sizes S M L XL\ncast-on(88 | 96 | 104)", err: "3 values given for 4 sizes"},
+		{text: "co(96 | 2)", err: "no sizes declared"},
+		{text: "co(97/2)", err: "test.knit:1:6: 97 / 2 does not divide evenly"},
+	}
+	for _, c := range cases {
+		rows, err := compile(t, c.text, c.size)
+		switch {
+		case c.err != "":
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%q gave %v, want an error containing %q", c.text, err, c.err)
+			}
+		case err != nil:
+			t.Errorf("%q gave %v", c.text, err)
+		case strings.Join(rows, "\n") != strings.Join(c.rows, "\n"):
+			t.Errorf("%q compiles to %q, want %q", c.text, rows, c.rows)
+		}
+	}
+}
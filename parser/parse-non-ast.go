@@ -16,7 +16,8 @@ func (p *Parser) parseSizeExprMinus() (*ast.SizeExpr, error) {
 		return nil, fmt.Errorf("%w%s", err, StackLine())
 	}
 	if t.Tok != IDENTIFIER_T && t.Tok != NUMERIC_T {
-		return nil, fmt.Errorf("%sValue following minus sign not valid", StackLine())
+		return nil, p.errorAt(t.Pos, "a minus means until that many stitches before the end, e.g. k(-2)",
+			"Expected a number or name following '-', not %s", describe(t))
 	}
 	size, err := p.parseSizeExpr(t)
 	if err != nil {
@@ -40,7 +41,8 @@ func (p *Parser) parseSizeExpr(t TokenContainer) (*ast.SizeExpr, error) {
 	tp := p.peekIgnoreWs()
 
 	switch tp.Tok {
-	case COMMA_T, RIGHT_PAREN_T:
+	case COMMA_T, RIGHT_PAREN_T, SLASH_T, PIPE_T, PLUS_T, MINUS_T, ASTERISK_T,
+		NEW_LINE_T, COMMENT_T, EOF_T, NEXT_SOURCE_T:
 		unit = ast.NOUNIT
 	case FEET_T:
 		p.nextIgnoreWs()
@@ -54,51 +56,126 @@ func (p *Parser) parseSizeExpr(t TokenContainer) (*ast.SizeExpr, error) {
 		} else if strings.EqualFold(tp.Str, "cm") {
 			unit = ast.CM
 		} else {
-			return nil, fmt.Errorf("%sNo valid unit identifier found:\n  %#v", StackLine(), tp)
+			return nil, p.errorAt(tp.Pos, "units are mm, cm, \" or '", "Unknown unit %s", describe(tp))
 		}
 		p.nextIgnoreWs()
 	default:
-		return nil, fmt.Errorf("%sNo valid unit token found:\n  %#v", StackLine(), tp)
+		return nil, p.errorAt(tp.Pos, "", "Unexpected %s following %s", describe(tp), t.Str)
 	}
 	return ast.NewSizeExpr(ni, nf, t, unit), nil
 }
 
+// Opening '"' already consumed
+func (p *Parser) parseStringExpr(quote TokenContainer) (*ast.StringExpr, error) {
+	t, err := p.lexer.NextQuoted()
+	if err != nil {
+		return nil, fmt.Errorf("%w%s", err, StackLine())
+	}
+	if t.Str == "" {
+		return nil, p.errorAt(quote.Pos, "", "Empty string")
+	}
+	return ast.NewStringExpr(t), nil
+}
+
+// First value already parsed, either '/' or '|' separate the rest
+func (p *Parser) parseVariantExpr(first *ast.SizeExpr) (*ast.VariantExpr, error) {
+	values := []*ast.SizeExpr{first}
+	for {
+		if tp := p.peekIgnoreWs().Tok; tp != SLASH_T && tp != PIPE_T {
+			return ast.NewVariantExpr(values), nil
+		}
+		p.nextIgnoreWs() // Consume separator
+		t, err := p.nextIgnoreWs()
+		if err != nil {
+			return nil, fmt.Errorf("%w%s", err, StackLine())
+		}
+		if t.Tok != NUMERIC_T {
+			return nil, p.errorAt(t.Pos, "", "Each size must have a number, not %s", describe(t))
+		}
+		size, err := p.parseSizeExpr(t)
+		if err != nil {
+			return nil, fmt.Errorf("%w%s", err, StackLine())
+		}
+		values = append(values, size)
+	}
+}
+
+const DEPTH_KEYWORD = "depth"
+
+// `depth` identifier already consumed and followed by '='
+func (p *Parser) parseDepth(ident TokenContainer, depth int) (int, error) {
+	if depth > 0 {
+		return 0, p.errorAt(ident.Pos, "", "Depth given twice")
+	}
+	p.nextIgnoreWs() // Consume '='
+	t, err := p.nextIgnoreWs()
+	if err != nil {
+		return 0, fmt.Errorf("%w%s", err, StackLine())
+	}
+	n, err := strconv.Atoi(t.Str)
+	if t.Tok != NUMERIC_T || err != nil || n < 1 {
+		return 0, p.errorAt(t.Pos, "depth is how many times deep an assignment may call itself, e.g. spiral(2, depth=3)",
+			"Expected a depth of 1 or more, not %s", describe(t))
+	}
+	return n, nil
+}
+
 // LEFT_PAREN_T already consumed
 func (p *Parser) parseBrackets() (ast.Brackets, error) {
 	args := make([]ast.Expr, 0)
+	depth := 0
 	for {
 		t, err := p.nextIgnoreWs()
 		if err != nil {
 			return ast.Brackets{}, err
 		}
 		switch t.Tok {
-		case IDENTIFIER_T:
-			args = append(args, ast.NewIdentExpr(t))
-
-		case MINUS_T, NUMERIC_T:
-			var size *ast.SizeExpr
-			var err error
-			if t.Tok == MINUS_T {
-				size, err = p.parseSizeExprMinus()
-			} else {
-				size, err = p.parseSizeExpr(t)
+		case IDENTIFIER_T, NUMERIC_T, LEFT_PAREN_T:
+			if t.Tok == IDENTIFIER_T && t.Str == DEPTH_KEYWORD && p.peekIgnoreWs().Tok == EQUALS_T {
+				if depth, err = p.parseDepth(t, depth); err != nil {
+					return ast.Brackets{}, err
+				}
+				continue
 			}
+			arg, err := p.parseArithExpr(t)
 			if err != nil {
-				return ast.Brackets{}, fmt.Errorf("%s : %w", StackLine(), err)
+				return ast.Brackets{}, fmt.Errorf("%w%s", err, StackLine())
+			}
+			args = append(args, arg)
+
+		case MINUS_T:
+			size, err := p.parseSizeExprMinus()
+			if err != nil {
+				return ast.Brackets{}, fmt.Errorf("%w%s", err, StackLine())
+			}
+			if tp := p.peekIgnoreWs().Tok; tp == SLASH_T || tp == PIPE_T {
+				variant, err := p.parseVariantExpr(size)
+				if err != nil {
+					return ast.Brackets{}, fmt.Errorf("%w%s", err, StackLine())
+				}
+				args = append(args, variant)
+				continue
 			}
 			args = append(args, size)
 
 		case ASTERISK_T:
 			args = append(args, ast.NewSizeExprAsterisk(t))
 
+		case INCHES_T:
+			str, err := p.parseStringExpr(t)
+			if err != nil {
+				return ast.Brackets{}, fmt.Errorf("%w%s", err, StackLine())
+			}
+			args = append(args, str)
+
 		case COMMA_T:
 			continue
 
 		case RIGHT_PAREN_T:
-			return ast.Brackets{Args: args}, nil
+			return ast.Brackets{Args: args, Depth: depth}, nil
 
 		default:
-			return ast.Brackets{}, fmt.Errorf("%sInvalid token in bracket group: %v", StackLine(), t)
+			return ast.Brackets{}, p.errorAt(t.Pos, "", "Unexpected %s in brackets", describe(t))
 		}
 	}
 }
@@ -11,29 +11,57 @@ import (
 )
 
 type Parser struct {
-	lexer Lexer
-	Root  ast.BlockStmt
+	lexer    Lexer
+	importer *importer
+	last     TokenContainer
+	diags    Diagnostics
+	Root     ast.BlockStmt
+	// The last `flat` or `round` in the braces being parsed
+	construction ast.Construction
 }
 
 func (o *Parser) WalkForLocals(e *ast.EngineData) {
 	o.Root.WalkForLocals(e)
 }
 
+// Errors are diagnostics, as the parser's are
 func (o *Parser) WalkForLines(e *ast.EngineData) error {
-	return o.Root.WalkForLines(e)
+	err := o.Root.WalkForLines(e)
+	if err == nil {
+		err = e.CheckSize()
+	}
+	if err != nil {
+		return Diagnose(err)
+	}
+	return nil
+}
+
+// Every comment in the input, in order, not just those kept as descriptions
+func (o *Parser) Comments() []ast.CommentExpr {
+	comments := make([]ast.CommentExpr, 0)
+	for _, t := range o.lexer.Comments() {
+		comments = append(comments, ast.CommentExpr{Semicolon: t.Pos, Str: t.Str})
+	}
+	return comments
 }
 
 func NewParserFromBlockStmt(root ast.BlockStmt) *Parser {
 	return &Parser{
-		lexer: Lexer{},
-		Root:  root,
+		lexer:    Lexer{},
+		importer: newImporter(),
+		last:     TokenContainer{},
+		diags:    make(Diagnostics, 0),
+		Root:     root,
 	}
 }
 
 func NewParser(lexer Lexer) *Parser {
 	return &Parser{
-		lexer: lexer,
-		Root:  *ast.NewBlockStmt(),
+		lexer:    lexer,
+		importer: newImporter(),
+		last:     TokenContainer{},
+		diags:    make(Diagnostics, 0),
+		Root:     *ast.NewBlockStmt(),
 	}
 }
 
@@ -51,18 +79,22 @@ func (p *Parser) parseSingleStitch(ident ast.IdentExpr) (ast.Expr, error) {
 			return nil, fmt.Errorf("%w%s", err, StackLine())
 		}
 
-	case IDENTIFIER_T, LEFT_BRACE_T, RIGHT_BRACE_T, COMMENT_T, NEW_LINE_T:
+	case IDENTIFIER_T, INCHES_T, LEFT_BRACE_T, RIGHT_BRACE_T, COMMENT_T, NEW_LINE_T:
 		break
 
 	default:
-		return nil, fmt.Errorf("Invalid token %v%s", tp, StackLine())
+		return nil, p.errorAt(tp.Pos, "stitches are separated by spaces and take their count in brackets, e.g. k(2)",
+			"Unexpected %s following %s", describe(tp), ident.Name)
 	}
 	return ast.NewStitchExpr(ident, args), nil
 }
 
 func (p *Parser) parseRowExpr(firstToken TokenContainer, first bool) (*ast.RowExpr, error) {
-	stitches := make([]ast.Expr, 0)
+	return p.parseRowExprFrom(make([]ast.Expr, 0), firstToken, first)
+}
 
+// Continues a row from any stitches already parsed
+func (p *Parser) parseRowExprFrom(stitches []ast.Expr, firstToken TokenContainer, first bool) (*ast.RowExpr, error) {
 	braced := firstToken.Tok == LEFT_BRACE_T
 
 	for {
@@ -91,9 +123,16 @@ func (p *Parser) parseRowExpr(firstToken TokenContainer, first bool) (*ast.RowEx
 			stitches = append(stitches, stitch)
 			// log.Debugf("stitches {%#v}\nstitch {%#v}\n", stitches, stitch)
 
+		case INCHES_T:
+			note, err := p.parseStringExpr(t)
+			if err != nil {
+				return nil, fmt.Errorf("%w%s", err, StackLine())
+			}
+			stitches = append(stitches, note)
+
 		case RIGHT_BRACE_T:
 			if !braced {
-				return nil, fmt.Errorf("Found closing brace without opener%s", StackLine())
+				return nil, p.errorAt(t.Pos, "", "Found '}' without a matching '{'")
 			}
 			var args ast.Brackets
 			var err error
@@ -101,55 +140,59 @@ func (p *Parser) parseRowExpr(firstToken TokenContainer, first bool) (*ast.RowEx
 				p.nextIgnoreWs()
 				args, err = p.parseBrackets()
 				if err != nil {
-					return nil, fmt.Errorf("Error parsing bracket group for row%s", StackLine())
+					return nil, fmt.Errorf("Error parsing bracket group for row: %w%s", err, StackLine())
 				}
 			}
 			return ast.NewRowExpr(stitches, args), nil
 
 		case NEW_LINE_T, EOF_T:
 			if braced {
-				return nil, fmt.Errorf("Newline before closing brace in row expression%s", StackLine())
+				return nil, p.errorAt(t.Pos, "a row in braces must close on the same line, a group starts with '{' on its own",
+					"Row starting at %s not closed before the end of the line", firstToken.Pos.Str())
 			}
 			return ast.NewRowExpr(stitches, ast.MakeBrackets()), nil
 
 		default:
-			return nil, fmt.Errorf("Invalid token in row: %v%s", t, StackLine())
+			return nil, p.errorAt(t.Pos, "", "Unexpected %s in row", describe(t))
 		}
 	}
 }
 
 // First '{' already consumed
 func (p *Parser) parseGroupExpr(lBrace TokenContainer) (*ast.GroupExpr, error) {
+	construction := p.construction
+	defer func() { p.construction = construction }()
+	p.importer.pushScope()
+	defer p.importer.popScope()
 	lines := make([]ast.Stmt, 0)
 	args := ast.MakeBrackets()
 	var rBrace TokenContainer
 	for {
 		tp := p.peekIgnoreWsCr()
 		if tp.Tok == RIGHT_BRACE_T {
-			var err error
-			rBrace, err = p.nextIgnoreWsCr()
-			if err != nil {
-				return nil, fmt.Errorf("Error finding matching brace for groups: %w%s", err, StackLine())
-			}
+			rBrace, _ = p.nextIgnoreWsCr() // Already peeked
 			if p.peekIgnoreWsCr().Tok == LEFT_PAREN_T {
 				p.nextIgnoreWsCr() // Consume '('
 				var err error
 				args, err = p.parseBrackets()
 				if err != nil {
-					return nil, fmt.Errorf("Error parsing bracket group for row%s", StackLine())
+					return nil, fmt.Errorf("Error parsing bracket group for group: %w%s", err, StackLine())
 				}
 			}
 			break
 		}
 
 		t, err := p.nextIgnoreWsCr()
-		if err != nil {
-			return nil, fmt.Errorf("%w%s", err, StackLine())
+		if err != nil || t.Tok == NEXT_SOURCE_T {
+			return nil, p.errorAt(lBrace.Pos, "every '{' needs a matching '}'", "Group is never closed")
 		}
 
+		// An error in a line doesn't stop the rest of the group being checked
 		line, err := p.parseLine(ast.CommentGroupExpr{List: make([]ast.CommentExpr, 0)}, t)
 		if err != nil {
-			return nil, fmt.Errorf("Error parsing rows: %w%s", err, StackLine())
+			p.diags = append(p.diags, p.diagnose(err)...)
+			p.skipLine()
+			continue
 		}
 
 		lines = append(lines, line)
@@ -162,7 +205,10 @@ func (p *Parser) parseStitches() (ast.Expr, error) {
 	if err != nil {
 		return nil, fmt.Errorf("%w%s", err, StackLine())
 	}
+	return p.parseStitchesFrom(t)
+}
 
+func (p *Parser) parseStitchesFrom(t TokenContainer) (ast.Expr, error) {
 	switch t.Tok {
 	case LEFT_BRACE_T: // Row or group statement
 		tp := p.peekIgnoreWs()
@@ -176,7 +222,7 @@ func (p *Parser) parseStitches() (ast.Expr, error) {
 			}
 			return s, err
 
-		case IDENTIFIER_T, LEFT_BRACE_T:
+		case IDENTIFIER_T, INCHES_T, LEFT_BRACE_T:
 			s, err := p.parseRowExpr(t, false)
 			if err != nil {
 				err = fmt.Errorf("%w%s", err, StackLine())
@@ -184,10 +230,10 @@ func (p *Parser) parseStitches() (ast.Expr, error) {
 			return s, err
 
 		default:
-			return nil, fmt.Errorf("Invalid token following left brace: %v%s", t, StackLine())
+			return nil, p.errorAt(tp.Pos, "", "Unexpected %s following '{'", describe(tp))
 		}
 
-	case IDENTIFIER_T: // Row or identifier assignment
+	case IDENTIFIER_T, INCHES_T: // Row or identifier assignment
 		s, err := p.parseRowExpr(t, true)
 		if err != nil {
 			err = fmt.Errorf("%w%s", err, StackLine())
@@ -195,7 +241,7 @@ func (p *Parser) parseStitches() (ast.Expr, error) {
 		return s, err
 
 	default:
-		return nil, fmt.Errorf("Invalid stitch: %v%s", t, StackLine())
+		return nil, p.errorAt(t.Pos, "", "Expected a stitch, not %s", describe(t))
 	}
 }
 
@@ -234,13 +280,78 @@ func (p *Parser) parseGroupStmt(desc ast.CommentGroupExpr, lBrace TokenContainer
 	return ast.NewGroupStmt(desc, *group), nil
 }
 
-func (p *Parser) parseAssignment(desc ast.CommentGroupExpr, ident ast.IdentExpr) (ast.Stmt, error) {
-	expr, err := p.parseStitches()
+// A rhs starting with a number or bracket, or an identifier followed by an
+// operator, is a number rather than stitches
+func (p *Parser) parseAssignment(desc ast.CommentGroupExpr, ident ast.IdentExpr, params []ast.IdentExpr) (ast.Stmt, error) {
+	t, err := p.nextIgnoreWs()
+	if err != nil {
+		return nil, fmt.Errorf("%w%s", err, StackLine())
+	}
+	if t.Tok == NUMERIC_T || t.Tok == LEFT_PAREN_T || t.Tok == IDENTIFIER_T && isArithOp(p.peekIgnoreWs().Tok) {
+		s, err := p.parseNumberStmt(desc, ident, params, t)
+		if err != nil {
+			err = fmt.Errorf("%w%s", err, StackLine())
+		}
+		return s, err
+	}
+
+	expr, err := p.parseStitchesFrom(t)
 	if err != nil {
 		return nil, fmt.Errorf("%w%s", err, StackLine())
 	}
 
-	return ast.NewAssignStmt(desc, ident, expr), nil
+	return ast.NewAssignStmt(desc, ident, params, expr), nil
+}
+
+func (p *Parser) parseParams(args ast.Brackets) ([]ast.IdentExpr, error) {
+	params := make([]ast.IdentExpr, 0, len(args.Args))
+	seen := make(map[string]bool)
+	for _, arg := range args.Args {
+		param, ok := arg.(*ast.IdentExpr)
+		if !ok {
+			return nil, p.errorAt(arg.Pos(), "", "Parameters must be identifiers")
+		}
+		if seen[param.Name] {
+			return nil, p.errorAt(param.Pos(), "", "Duplicate parameter %s", param.Name)
+		}
+		seen[param.Name] = true
+		params = append(params, *param)
+	}
+	return params, nil
+}
+
+// Either a parameterised assignment or a row starting with a called stitch
+func (p *Parser) parseIdentExprWithArgs(desc ast.CommentGroupExpr, firstToken TokenContainer) (ast.Stmt, error) {
+	ident := ast.MakeIdentExpr(firstToken)
+
+	p.nextIgnoreWs() // Consume '('
+	args, err := p.parseBrackets()
+	if err != nil {
+		return nil, fmt.Errorf("%w%s", err, StackLine())
+	}
+
+	if p.peekIgnoreWs().Tok == EQUALS_T {
+		p.nextIgnoreWs() // Consume '='
+		params, err := p.parseParams(args)
+		if err != nil {
+			return nil, fmt.Errorf("%w%s", err, StackLine())
+		}
+		s, err := p.parseAssignment(desc, ident, params)
+		if assign, ok := s.(*ast.AssignStmt); ok {
+			assign.Depth = args.Depth
+		}
+		if err != nil {
+			err = fmt.Errorf("%w%s", err, StackLine())
+		}
+		return s, err
+	}
+
+	stitches := []ast.Expr{ast.NewStitchExpr(ident, args)}
+	row, err := p.parseRowExprFrom(stitches, firstToken, false)
+	if err != nil {
+		return nil, fmt.Errorf("%w%s", err, StackLine())
+	}
+	return ast.NewRowStmt(desc, *row), nil
 }
 
 func (p *Parser) parseAlias(desc ast.CommentGroupExpr, lhs ast.IdentExpr) (ast.Stmt, error) {
@@ -248,13 +359,46 @@ func (p *Parser) parseAlias(desc ast.CommentGroupExpr, lhs ast.IdentExpr) (ast.S
 	if err != nil {
 		return nil, fmt.Errorf("%w%s", err, StackLine())
 	}
+	var rhs ast.IdentExpr
 	switch t.Tok {
 	case IDENTIFIER_T:
-		rhs := ast.MakeIdentExpr(t)
-		return ast.NewAliasStmt(desc, lhs, rhs), nil
+		rhs = ast.MakeIdentExpr(t)
+	case INCHES_T: // Quoted, so it may have spaces
+		str, err := p.parseStringExpr(t)
+		if err != nil {
+			return nil, fmt.Errorf("%w%s", err, StackLine())
+		}
+		rhs = ast.IdentExpr{At: str.At, Name: str.Value}
+	case COLOUR_T: // Only a colour, named for its value
+		if err := p.checkColour(t); err != nil {
+			return nil, err
+		}
+		alias := ast.NewAliasStmt(desc, lhs, ast.MakeIdentExpr(t))
+		alias.Colour = t.Str
+		return alias, nil
 	default:
-		return nil, fmt.Errorf("Invalid alias, %v%s", t, StackLine())
+		return nil, p.errorAt(t.Pos, "an alias names one stitch, e.g. k := knit or kfb := \"knit front and back\"",
+			"Expected a stitch to alias, not %s", describe(t))
+	}
+
+	alias := ast.NewAliasStmt(desc, lhs, rhs)
+	if tp := p.peekIgnoreWs(); tp.Tok == COLOUR_T {
+		p.nextIgnoreWs() // Consume colour
+		if err := p.checkColour(tp); err != nil {
+			return nil, err
+		}
+		alias.Colour = tp.Str
+	}
+	return alias, nil
+}
+
+// `#rgb` or `#rrggbb`
+func (p *Parser) checkColour(t TokenContainer) error {
+	if digits := len(t.Str) - 1; digits != 3 && digits != 6 {
+		return p.errorAt(t.Pos, "a colour is given in hex, e.g. MC := navy #1b2a4a",
+			"Colour %s must have 3 or 6 hex digits", t.Str)
 	}
+	return nil
 }
 
 // ------------------ Lines ------------------
@@ -275,13 +419,70 @@ func (p *Parser) parseIdentExprLine(desc ast.CommentGroupExpr, firstToken TokenC
 
 	case EQUALS_T:
 		p.nextIgnoreWs() // Consume '='
-		s, err := p.parseAssignment(desc, ident)
+		s, err := p.parseAssignment(desc, ident, make([]ast.IdentExpr, 0))
+		if err != nil {
+			err = fmt.Errorf("%w%s", err, StackLine())
+		}
+		return s, err
+
+	case INCHES_T:
+		var s ast.Stmt
+		var err error
+		if ident.Name == IMPORT_KEYWORD {
+			s, err = p.parseImport(desc, ident)
+		} else {
+			s, err = p.parseRowStmt(desc, firstToken, true)
+		}
+		if err != nil {
+			err = fmt.Errorf("%w%s", err, StackLine())
+		}
+		return s, err
+
+	case LEFT_PAREN_T:
+		var s ast.Stmt
+		var err error
+		if ident.Name == GAUGE_KEYWORD {
+			s, err = p.parseGaugeStmt(desc, ident)
+		} else {
+			s, err = p.parseIdentExprWithArgs(desc, firstToken)
+		}
+		if err != nil {
+			err = fmt.Errorf("%w%s", err, StackLine())
+		}
+		return s, err
+
+	case IDENTIFIER_T:
+		var s ast.Stmt
+		var err error
+		switch ident.Name {
+		case STITCH_KEYWORD:
+			s, err = p.parseStitchStmtOrRow(desc, firstToken)
+		case CHART_KEYWORD:
+			s, err = p.parseChartStmtOrRow(desc, firstToken)
+		case SIZES_KEYWORD:
+			s, err = p.parseSizesStmt(desc, ident)
+		default:
+			s, err = p.parseRowStmt(desc, firstToken, true)
+		}
 		if err != nil {
 			err = fmt.Errorf("%w%s", err, StackLine())
 		}
 		return s, err
 
-	case LEFT_PAREN_T, LEFT_BRACE_T, IDENTIFIER_T, NEW_LINE_T:
+	case NEW_LINE_T, EOF_T, NEXT_SOURCE_T:
+		if ident.Name == FLAT_KEYWORD || ident.Name == ROUND_KEYWORD {
+			return p.parseConstructionStmt(desc, ident), nil
+		}
+		if tp.Tok != NEW_LINE_T {
+			return nil, p.errorAt(tp.Pos, "", "Unexpected %s following %s", describe(tp), ident.Name)
+		}
+		s, err := p.parseRowStmt(desc, firstToken, true)
+		if err != nil {
+			err = fmt.Errorf("%w%s", err, StackLine())
+		}
+		return s, err
+
+	case LEFT_BRACE_T:
 		s, err := p.parseRowStmt(desc, firstToken, true)
 		if err != nil {
 			err = fmt.Errorf("%w%s", err, StackLine())
@@ -289,7 +490,7 @@ func (p *Parser) parseIdentExprLine(desc ast.CommentGroupExpr, firstToken TokenC
 		return s, err
 
 	default:
-		return nil, fmt.Errorf("Invalid token following identifier %v%s", tp, StackLine())
+		return nil, p.errorAt(tp.Pos, "", "Unexpected %s following %s", describe(tp), ident.Name)
 	}
 }
 
@@ -302,14 +503,14 @@ func (p *Parser) parseBraceLine(desc ast.CommentGroupExpr, firstToken TokenConta
 			err = fmt.Errorf("%w%s", err, StackLine())
 		}
 		return s, err
-	case IDENTIFIER_T, LEFT_BRACE_T:
+	case IDENTIFIER_T, INCHES_T, LEFT_BRACE_T:
 		s, err := p.parseRowStmt(desc, firstToken, !(firstToken.Tok == LEFT_BRACE_T))
 		if err != nil {
 			err = fmt.Errorf("%w%s", err, StackLine())
 		}
 		return s, err
 	default:
-		return nil, fmt.Errorf("Invalid token following brace at SOL: %v%s", tp, StackLine())
+		return nil, p.errorAt(tp.Pos, "", "Unexpected %s following '{'", describe(tp))
 	}
 }
 
@@ -346,12 +547,25 @@ func (p *Parser) parseLine(desc ast.CommentGroupExpr, firstToken TokenContainer)
 		}
 		return s, err
 
+	case INCHES_T: // A row starting with a note
+		s, err := p.parseRowStmt(desc, firstToken, true)
+		if err != nil {
+			err = fmt.Errorf("%w%s", err, StackLine())
+		}
+		return s, err
+
 	default:
-		return nil, fmt.Errorf("Illegal start of line : %v%s", firstToken, StackLine())
+		return nil, p.errorAt(firstToken.Pos, "a line starts with a stitch, a note, an alias or assignment, or '{'",
+			"Unexpected %s at the start of a line", describe(firstToken))
 	}
 }
 
+// Every line is parsed, the errors in them are returned together as
+// `Diagnostics`
 func (p *Parser) Parse() error {
+	p.importer.enter(p.lexer.CurrentFile())
+	defer p.importer.leave()
+
 	if t := p.peek(); t.Tok == COMMENT_T {
 		p.Root.Desc = p.parseCommentExpr(p.next())
 	}
@@ -361,11 +575,16 @@ func (p *Parser) Parse() error {
 
 		t := p.next()
 		if t.Tok == EOF_T {
+			if len(p.diags) > 0 {
+				return p.diags
+			}
 			return nil
 		}
 
 		if t.Tok == NEXT_SOURCE_T {
 			log.Info("Moving to next source")
+			p.importer.leave()
+			p.importer.enter(p.lexer.CurrentFile())
 			if t := p.peek(); t.Tok == COMMENT_T {
 				p.Root.Desc = p.parseCommentExpr(p.next())
 			}
@@ -378,7 +597,8 @@ func (p *Parser) Parse() error {
 		}
 
 		if stmt, err := p.parseLine(desc, t); err != nil {
-			return fmt.Errorf("%w%s", err, StackLine())
+			p.diags = append(p.diags, p.diagnose(err)...)
+			p.skipLine()
 		} else {
 			p.Root.Block = append(p.Root.Block, stmt)
 		}
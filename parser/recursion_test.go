@@ -0,0 +1,52 @@
+package parser_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Every call in the circle is given with where it was made
+func TestRecursionCycle(t *testing.T) {
+	_, err := compile(t, "a = { b }\nb = { k a }\nco(4)\na\n", "")
+	want := "test.knit:2:9: a is called within itself, a at test.knit:4:1 -> b at test.knit:1:7 -> a at test.knit:2:9"
+	if err == nil || err.Error() != want {
+		t.Errorf("Got %v, want %s", err, want)
+	}
+}
+
+func TestRecursionDepth(t *testing.T) {
+	spiral := "co(4)\nspiral(n%s) = {\n  k(n)\n  spiral(n)\n}\nspiral(4%s)\n"
+	cases := map[[2]string]int{
+		{"", ", depth=3"}:          4,
+		{", depth=1", ""}:          2,
+		{", depth=1", ", depth=2"}: 3,
+	}
+	for depths, n := range cases {
+		rows, err := compile(t, fmt.Sprintf(spiral, depths[0], depths[1]), "")
+		if err != nil {
+			t.Fatalf("%q: %v", depths, err)
+		}
+		want := []string{"co 4"}
+		for i := 0; i < n; i++ {
+			want = append(want, "k 4")
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Errorf("%q gives %q, want %q", depths, rows, want)
+		}
+	}
+}
+
+func TestRecursionDepthErrors(t *testing.T) {
+	cases := map[string]string{
+		"a = { k }\na(depth=0)\n":          "test.knit:2:9: Expected a depth of 1 or more, not '0'",
+		"a = { k }\na(depth=-1)\n":         "test.knit:2:9: Expected a depth of 1 or more, not '-'",
+		"a(depth=0) = { k }\n":             "test.knit:1:9: Expected a depth of 1 or more, not '0'",
+		"a = { k }\na(depth=2, depth=3)\n": "test.knit:2:12: Depth given twice",
+	}
+	for text, want := range cases {
+		if got := parseError(t, text).Error(); got != want {
+			t.Errorf("%q gives %q, want %q", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package parser_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUseBeforeDeclare(t *testing.T) {
+	cases := map[string]string{
+		"co(4)\nfoo\nfoo := knit\n":             "test.knit:2:1: foo used before its declaration at test.knit:3:1",
+		"co(4)\na = {\n  k(n)\n  n = 3\n}\na\n": "test.knit:3:5: n used before its declaration at test.knit:4:3",
+	}
+	for text, want := range cases {
+		if _, err := compile(t, text, ""); err == nil || err.Error() != want {
+			t.Errorf("%q gives %v, want %s", text, err, want)
+		}
+	}
+}
+
+// A declaration inside braces hides one outside them, only until they close
+func TestShadowing(t *testing.T) {
+	cases := map[string][]string{
+		"co(4)\nyolk = {\n  p := knit\n  p(4)\n}\nyolk\np(4)\n":    {"co 4", "knit 4", "p 4"},
+		"co(4)\nn = 2\nyolk = {\n  n = 3\n  k(n)\n}\nyolk\nk(n)\n": {"co 4", "k 3", "k 2"},
+		"co(4)\nn = 2\na = {\n  n = 3\n  b = {\n    n = 4\n    k(n)\n  }\n  b\n  k(n)\n}\na\nk(n)\n": {
+			"co 4", "k 4", "k 3", "k 2",
+		},
+		"co(4)\na = {\n  b := knit\n}\na\nb(4)\n": {"co 4", "b 4"},
+	}
+	for text, want := range cases {
+		rows, err := compile(t, text, "")
+		if err != nil {
+			t.Fatalf("%q: %v", text, err)
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Errorf("%q gives %q, want %q", text, rows, want)
+		}
+	}
+}
+
+// An import inside braces is for them alone, it's included again in the next
+// braces that import it and isn't seen outside them
+func TestImportScope(t *testing.T) {
+	dir, remove := writePatterns(t, map[string]string{
+		"pattern.knit": "co(4)\na = {\n  import \"defs.knit\"\n  K(4)\n}\nb = {\n  import \"defs.knit\"\n  K(4)\n}\na\nb\nK(4)\n",
+		"defs.knit":    "K := knit\n",
+	})
+	defer remove()
+
+	p, err := parseFile(filepath.Join(dir, "pattern.knit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := walk(t, p, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"co 4", "knit 4", "knit 4", "K 4"}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("Gives %q, want %q", rows, want)
+	}
+}
@@ -0,0 +1,45 @@
+package parser
+
+import (
+	"github.com/bodneyc/knit-and-go/ast"
+	. "github.com/bodneyc/knit-and-go/lexer"
+)
+
+const SIZES_KEYWORD = "sizes"
+
+// `sizes` already consumed, the names run to the end of the line and may be
+// separated by commas
+func (p *Parser) parseSizesStmt(desc ast.CommentGroupExpr, ident ast.IdentExpr) (ast.Stmt, error) {
+	names := make([]ast.IdentExpr, 0)
+	seen := make(map[string]bool)
+	for {
+		switch tp := p.peekIgnoreWs(); tp.Tok {
+		case NEW_LINE_T, EOF_T, NEXT_SOURCE_T, COMMENT_T:
+			if len(names) == 0 {
+				return nil, p.errorAt(ident.Pos(), "", "No sizes given")
+			}
+			p.importer.sizes = len(names)
+			for _, values := range p.importer.slashed {
+				if len(values) == len(names) {
+					p.diags = append(p.diags, slashedErr(values))
+				}
+			}
+			p.importer.slashed = nil
+			return ast.NewSizesStmt(desc, ident.Pos(), names), nil
+
+		case COMMA_T:
+			p.nextIgnoreWs()
+
+		case IDENTIFIER_T, NUMERIC_T:
+			t, _ := p.nextIgnoreWs()
+			if seen[t.Str] {
+				return nil, p.errorAt(t.Pos, "", "Size %s given more than once", t.Str)
+			}
+			seen[t.Str] = true
+			names = append(names, ast.MakeIdentExpr(t))
+
+		default:
+			return nil, p.errorAt(tp.Pos, "", "Unexpected %s in sizes", describe(tp))
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bodneyc/knit-and-go/ast"
+	. "github.com/bodneyc/knit-and-go/lexer"
+	. "github.com/bodneyc/knit-and-go/util"
+)
+
+const STITCH_KEYWORD = "stitch"
+
+// `stitch` identifier already consumed and followed by another identifier, if
+// that isn't followed by a brace it's just a row
+func (p *Parser) parseStitchStmtOrRow(desc ast.CommentGroupExpr, firstToken TokenContainer) (ast.Stmt, error) {
+	nameToken, err := p.nextIgnoreWs()
+	if err != nil {
+		return nil, fmt.Errorf("%w%s", err, StackLine())
+	}
+
+	if p.peekIgnoreWs().Tok != LEFT_BRACE_T {
+		first := ast.NewStitchExpr(ast.MakeIdentExpr(firstToken), ast.MakeBrackets())
+		second, err := p.parseSingleStitch(ast.MakeIdentExpr(nameToken))
+		if err != nil {
+			return nil, fmt.Errorf("%w%s", err, StackLine())
+		}
+		row, err := p.parseRowExprFrom([]ast.Expr{first, second}, nameToken, false)
+		if err != nil {
+			return nil, fmt.Errorf("%w%s", err, StackLine())
+		}
+		return ast.NewRowStmt(desc, *row), nil
+	}
+
+	p.nextIgnoreWs() // Consume '{'
+	def, err := p.parseStitchDef()
+	if err != nil {
+		return nil, fmt.Errorf("%w%s", err, StackLine())
+	}
+	stmt := ast.NewStitchStmt(desc, ast.MakeIdentExpr(nameToken), def)
+	stmt.RBrace = p.last.Pos
+	p.importer.scope.DeclareStitch(stmt)
+	return stmt, nil
+}
+
+func (p *Parser) parseStitchDefCount(property TokenContainer) (int, error) {
+	t, err := p.nextIgnoreWs()
+	if err != nil {
+		return 0, fmt.Errorf("%w%s", err, StackLine())
+	}
+	n, err := strconv.Atoi(t.Str)
+	if t.Tok != NUMERIC_T || err != nil || n < 0 {
+		return 0, p.errorAt(t.Pos, "", "%s must be a whole number, not %s", property.Str, describe(t))
+	}
+	return n, nil
+}
+
+// '{' already consumed, unset counts are a single stitch, or none for setup
+func (p *Parser) parseStitchDef() (ast.StitchDef, error) {
+	def := ast.StitchDef{Consumes: 1, Produces: 1, Lean: ast.NO_LEAN, Symbol: "", Marker: ast.NO_MARKER}
+	consumes, produces := false, false
+	for {
+		t, err := p.nextIgnoreWsCr()
+		if err != nil {
+			return def, fmt.Errorf("Unclosed stitch definition: %w%s", err, StackLine())
+		}
+
+		switch t.Tok {
+		case RIGHT_BRACE_T:
+			if def.Setup && !consumes {
+				def.Consumes = 0
+			}
+			if def.Setup && !produces {
+				def.Produces = 0
+			}
+			return def, nil
+
+		case COMMENT_T:
+			continue
+
+		case IDENTIFIER_T:
+			switch strings.ToLower(t.Str) {
+			case "consumes":
+				if def.Consumes, err = p.parseStitchDefCount(t); err != nil {
+					return def, err
+				}
+				consumes = true
+
+			case "produces":
+				if def.Produces, err = p.parseStitchDefCount(t); err != nil {
+					return def, err
+				}
+				produces = true
+
+			case "lean":
+				lean, err := p.nextIgnoreWs()
+				if err != nil {
+					return def, fmt.Errorf("%w%s", err, StackLine())
+				}
+				switch strings.ToLower(lean.Str) {
+				case "left":
+					def.Lean = ast.LEFT_LEAN
+				case "right":
+					def.Lean = ast.RIGHT_LEAN
+				case "none":
+					def.Lean = ast.NO_LEAN
+				default:
+					return def, p.errorAt(lean.Pos, "", "lean must be left, right or none, not %s", describe(lean))
+				}
+
+			case "marker":
+				role, err := p.nextIgnoreWs()
+				if err != nil {
+					return def, fmt.Errorf("%w%s", err, StackLine())
+				}
+				switch strings.ToLower(role.Str) {
+				case "place":
+					def.Marker = ast.PLACE_MARKER
+				case "slip":
+					def.Marker = ast.SLIP_MARKER
+				case "remove":
+					def.Marker = ast.REMOVE_MARKER
+				case "none":
+					def.Marker = ast.NO_MARKER
+				default:
+					return def, p.errorAt(role.Pos, "", "marker must be place, slip, remove or none, not %s", describe(role))
+				}
+
+			case "setup":
+				def.Setup = true
+
+			case "symbol":
+				if quote, err := p.nextIgnoreWs(); err != nil || quote.Tok != INCHES_T {
+					return def, p.errorAt(t.Pos, "", "symbol must be quoted")
+				}
+				symbol, err := p.lexer.NextQuoted()
+				if err != nil {
+					return def, fmt.Errorf("%w%s", err, StackLine())
+				}
+				def.Symbol = symbol.Str
+
+			default:
+				return def, p.errorAt(t.Pos, "", "Unknown stitch property %s", t.Str)
+			}
+
+		default:
+			return def, p.errorAt(t.Pos, "", "Unexpected %s in stitch definition", describe(t))
+		}
+	}
+}
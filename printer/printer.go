@@ -0,0 +1,631 @@
+package printer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/bodneyc/knit-and-go/ast"
+	. "github.com/bodneyc/knit-and-go/lexer"
+)
+
+const INDENT = "  "
+
+// ------------------ Printer ------------------
+
+// A line of output and the line of input it came from, 0 if it didn't come
+// from one line in particular
+type line struct {
+	indent int
+	text   string
+	src    int
+}
+
+type printer struct {
+	out      []line
+	comments []ast.CommentExpr
+	next     int
+	// Comments of the same description stay together, a blank line would
+	// split them
+	groups map[Position]int
+	last   int
+	opened bool
+	err    error
+}
+
+// Canonical `.knit` for the pattern, `comments` are every comment in the input
+// in order, without them only the descriptions kept in the AST are printed
+func Fprint(w io.Writer, root *ast.BlockStmt, comments []ast.CommentExpr) error {
+	p := &printer{groups: make(map[Position]int)}
+	descs := make([]ast.CommentGroupExpr, 0)
+	descs = append(descs, root.Desc)
+	descs = collectDescs(descs, root.Block)
+	for i, desc := range descs {
+		for _, c := range desc.List {
+			p.groups[c.Semicolon] = i + 1
+		}
+	}
+	if comments == nil {
+		comments = make([]ast.CommentExpr, 0)
+		for _, desc := range descs {
+			comments = append(comments, desc.List...)
+		}
+	}
+	p.comments = append(make([]ast.CommentExpr, 0, len(comments)), comments...)
+	sort.SliceStable(p.comments, func(i, j int) bool {
+		a, b := p.comments[i].Semicolon, p.comments[j].Semicolon
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+
+	// A comment first in the input is the description of the whole pattern,
+	// one after a blank line is that of the first statement
+	if len(root.Desc.List) == 0 && len(p.comments) > 0 && len(root.Block) > 0 &&
+		p.comments[0].Semicolon.Line < stmtLine(root.Block[0]) {
+		p.out = append(p.out, line{})
+	}
+
+	p.block(root.Block, 0)
+	for p.next < len(p.comments) {
+		p.comment(0)
+	}
+	if p.err != nil {
+		return p.err
+	}
+
+	_, err := w.Write(p.bytes())
+	return err
+}
+
+// Formatted into a string, for when there's nothing to write to
+func Sprint(root *ast.BlockStmt, comments []ast.CommentExpr) (string, error) {
+	var buf bytes.Buffer
+	if err := Fprint(&buf, root, comments); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (p *printer) bytes() []byte {
+	var buf bytes.Buffer
+	for _, l := range p.out {
+		if l.text != "" {
+			buf.WriteString(strings.Repeat(INDENT, l.indent))
+			buf.WriteString(l.text)
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// Descriptions of statements the printer walks, not those of chart rows, which
+// are made up from the chart
+func collectDescs(descs []ast.CommentGroupExpr, stmts []ast.Stmt) []ast.CommentGroupExpr {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.AliasStmt:
+			descs = append(descs, s.Desc)
+		case *ast.AssignStmt:
+			descs = append(descs, s.Desc)
+			if group, ok := s.Rhs.(*ast.GroupExpr); ok && s.Chart == nil {
+				descs = collectDescs(descs, group.Lines)
+			}
+		case *ast.RowStmt:
+			descs = append(descs, s.Desc)
+		case *ast.GroupStmt:
+			descs = append(descs, s.Desc)
+			descs = collectDescs(descs, s.Group.Lines)
+		case *ast.StitchStmt:
+			descs = append(descs, s.Desc)
+		case *ast.SizesStmt:
+			descs = append(descs, s.Desc)
+		case *ast.ImportStmt:
+			descs = append(descs, s.Desc)
+		case *ast.NumberStmt:
+			descs = append(descs, s.Desc)
+		case *ast.GaugeStmt:
+			descs = append(descs, s.Desc)
+		case *ast.ConstructionStmt:
+			descs = append(descs, s.Desc)
+		}
+	}
+	return descs
+}
+
+// Only blank lines of the input are kept, at most one at a time, and never
+// straight after an opening brace
+func (p *printer) gap(src int) {
+	if p.opened {
+		p.opened = false
+		return
+	}
+	if p.last > 0 && src-p.last > 1 {
+		p.out = append(p.out, line{})
+	}
+}
+
+func (p *printer) code(indent int, text string, src int) {
+	if src > 0 {
+		p.gap(src)
+	}
+	p.out = append(p.out, line{indent: indent, text: text, src: src})
+	if src > 0 {
+		p.last = src
+	}
+	p.opened = false
+	p.trailing()
+}
+
+// A comment on the same line as the code before it stays there
+func (p *printer) trailing() {
+	for p.next < len(p.comments) && p.last > 0 && p.comments[p.next].Semicolon.Line == p.last {
+		l := &p.out[len(p.out)-1]
+		l.text = fmt.Sprintf("%s %s", l.text, commentText(p.comments[p.next]))
+		p.next++
+	}
+}
+
+func commentText(c ast.CommentExpr) string {
+	return string(COMMENT_LITERAL) + strings.TrimRightFunc(c.Str, unicode.IsSpace)
+}
+
+func (p *printer) comment(indent int) {
+	c := p.comments[p.next]
+	together := false
+	if p.next > 0 {
+		prev := p.comments[p.next-1]
+		group, ok := p.groups[c.Semicolon]
+		together = ok && p.groups[prev.Semicolon] == group
+	}
+	if !together {
+		p.gap(c.Semicolon.Line)
+	}
+	p.out = append(p.out, line{indent: indent, text: commentText(c), src: c.Semicolon.Line})
+	p.last = c.Semicolon.Line
+	p.opened = false
+	p.next++
+}
+
+// Comments before line `src`
+func (p *printer) commentsBefore(indent int, src int) {
+	for p.next < len(p.comments) && p.comments[p.next].Semicolon.Line < src {
+		p.comment(indent)
+	}
+}
+
+// ------------------ Statements ------------------
+
+func stmtLine(stmt ast.Stmt) int {
+	return stmt.Pos().Line
+}
+
+func (p *printer) block(stmts []ast.Stmt, indent int) {
+	for i := 0; i < len(stmts); i++ {
+		if _, ok := stmts[i].(*ast.AliasStmt); ok {
+			i = p.aliases(stmts, i, indent) - 1
+			continue
+		}
+		p.commentsBefore(indent, stmtLine(stmts[i]))
+		p.stmt(stmts[i], indent)
+	}
+}
+
+// Aliases on consecutive lines are lined up on their `:=`, returns the index
+// after the last of them
+func (p *printer) aliases(stmts []ast.Stmt, start int, indent int) int {
+	end := start + 1
+	for ; end < len(stmts); end++ {
+		alias, ok := stmts[end].(*ast.AliasStmt)
+		if !ok {
+			break
+		}
+		if alias.Pos().Line != stmtLine(stmts[end-1])+1 {
+			break
+		}
+	}
+	width := 0
+	for _, stmt := range stmts[start:end] {
+		if n := len([]rune(stmt.(*ast.AliasStmt).Lhs.Name)); n > width {
+			width = n
+		}
+	}
+	for _, stmt := range stmts[start:end] {
+		alias := stmt.(*ast.AliasStmt)
+		p.commentsBefore(indent, stmtLine(alias))
+		lhs := alias.Lhs.Name + strings.Repeat(" ", width-len([]rune(alias.Lhs.Name)))
+		p.code(indent, fmt.Sprintf("%s := %s", lhs, aliasRhs(alias)), stmtLine(alias))
+	}
+	return end
+}
+
+func (p *printer) stmt(stmt ast.Stmt, indent int) {
+	src := stmtLine(stmt)
+	switch s := stmt.(type) {
+	case *ast.AliasStmt:
+		p.code(indent, fmt.Sprintf("%s := %s", s.Lhs.Name, aliasRhs(s)), src)
+
+	case *ast.AssignStmt:
+		p.assign(s, indent)
+
+	case *ast.RowStmt:
+		p.code(indent, p.rowText(&s.Row, rowBraced(&s.Row)), src)
+
+	case *ast.GroupStmt:
+		p.group(&s.Group, indent, "", src)
+
+	case *ast.StitchStmt:
+		p.stitchDef(s, indent)
+
+	case *ast.SizesStmt:
+		names := make([]string, len(s.Names))
+		for i, name := range s.Names {
+			names[i] = name.Name
+		}
+		p.code(indent, fmt.Sprintf("sizes %s", strings.Join(names, " ")), src)
+
+	case *ast.ImportStmt:
+		p.code(indent, fmt.Sprintf("import %s", quote(s.Path)), src)
+
+	case *ast.NumberStmt:
+		rhs := p.exprText(s.Rhs, false)
+		// A name alone would be a row of one stitch
+		if _, ok := s.Rhs.(*ast.IdentExpr); ok {
+			rhs = fmt.Sprintf("(%s)", rhs)
+		}
+		p.code(indent, fmt.Sprintf("%s = %s", s.Lhs.Name, rhs), src)
+
+	case *ast.GaugeStmt:
+		p.code(indent, gaugeText(s), src)
+
+	case *ast.ConstructionStmt:
+		p.code(indent, string(s.In), src)
+
+	default:
+		if p.err == nil {
+			p.err = fmt.Errorf("%s: Can't print %T", stmt.Pos().Str(), stmt)
+		}
+	}
+}
+
+func (p *printer) assign(s *ast.AssignStmt, indent int) {
+	if s.Chart != nil {
+		p.chart(s, indent)
+		return
+	}
+	lhs := s.Lhs.Name
+	if len(s.Params) > 0 || s.Depth > 0 {
+		params := make([]string, len(s.Params))
+		for i, param := range s.Params {
+			params[i] = param.Name
+		}
+		lhs = fmt.Sprintf("%s(%s)", lhs, strings.Join(append(params, depthText(s.Depth)...), ", "))
+	}
+	switch rhs := s.Rhs.(type) {
+	case *ast.GroupExpr:
+		p.group(rhs, indent, lhs+" = ", stmtLine(s))
+	case *ast.RowExpr:
+		p.code(indent, fmt.Sprintf("%s = %s", lhs, p.rowText(rhs, rowBraced(rhs))), stmtLine(s))
+	default:
+		p.code(indent, fmt.Sprintf("%s = %s", lhs, p.exprText(rhs, false)), stmtLine(s))
+	}
+}
+
+func (p *printer) group(g *ast.GroupExpr, indent int, before string, src int) {
+	p.code(indent, before+"{", src)
+	p.opened = true
+	p.block(g.Lines, indent+1)
+	p.commentsBefore(indent+1, g.RBrace.Line)
+	p.opened = false
+	p.out = append(p.out, line{indent: indent, text: "}" + p.argsText(g.Args), src: g.RBrace.Line})
+	p.last = g.RBrace.Line
+	p.trailing()
+}
+
+// Properties that aren't the default, on one line if there's only the one;
+// setup works no stitches unless told to
+func (p *printer) stitchDef(s *ast.StitchStmt, indent int) {
+	props := make([]string, 0)
+	counts := make([]string, 0)
+	unset := 1
+	if s.Def.Setup {
+		unset = 0
+	}
+	if s.Def.Consumes != unset {
+		counts = append(counts, fmt.Sprintf("consumes %d", s.Def.Consumes))
+	}
+	if s.Def.Produces != unset {
+		counts = append(counts, fmt.Sprintf("produces %d", s.Def.Produces))
+	}
+	if len(counts) > 0 {
+		props = append(props, strings.Join(counts, " "))
+	}
+	if s.Def.Lean != ast.NO_LEAN {
+		props = append(props, fmt.Sprintf("lean %s", s.Def.Lean))
+	}
+	if s.Def.Symbol != "" {
+		props = append(props, fmt.Sprintf("symbol %s", quote(s.Def.Symbol)))
+	}
+	if s.Def.Marker != ast.NO_MARKER {
+		props = append(props, fmt.Sprintf("marker %s", s.Def.Marker))
+	}
+	if s.Def.Setup {
+		props = append(props, "setup")
+	}
+
+	end := s.RBrace.Line
+	inside := p.next
+	for inside < len(p.comments) && p.comments[inside].Semicolon.Line <= end && end > 0 {
+		inside++
+	}
+	head := fmt.Sprintf("stitch %s {", s.Name.Name)
+	switch {
+	case len(props) == 0 && inside == p.next:
+		p.code(indent, head+"}", stmtLine(s))
+		return
+	case len(props) == 1 && inside == p.next:
+		p.code(indent, fmt.Sprintf("%s %s }", head, props[0]), stmtLine(s))
+		return
+	}
+
+	p.code(indent, head, stmtLine(s))
+	for _, prop := range props {
+		p.out = append(p.out, line{indent: indent + 1, text: prop})
+	}
+	// Comments in the braces are skipped by the parser, so they only need to
+	// stay inside them
+	for ; p.next < inside; p.next++ {
+		p.out = append(p.out, line{indent: indent + 1, text: commentText(p.comments[p.next])})
+	}
+	p.out = append(p.out, line{indent: indent, text: "}", src: end})
+	if end > 0 {
+		p.last = end
+	}
+	p.trailing()
+}
+
+// The chart as it was drawn, only moved to the indent of the braces it's in
+func (p *printer) chart(s *ast.AssignStmt, indent int) {
+	p.code(indent, fmt.Sprintf("chart %s {", s.Lhs.Name), stmtLine(s))
+
+	prefix, first := "", true
+	for _, l := range s.Chart {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		lead := l[:len(l)-len(strings.TrimLeftFunc(l, unicode.IsSpace))]
+		if first {
+			prefix, first = lead, false
+		}
+		for !strings.HasPrefix(lead, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	// Blank lines only between the lines of the chart, one at a time
+	lines := make([]string, 0, len(s.Chart))
+	for _, l := range s.Chart {
+		l = strings.TrimRightFunc(strings.TrimPrefix(l, prefix), unicode.IsSpace)
+		if l == "" && (len(lines) == 0 || lines[len(lines)-1] == "") {
+			continue
+		}
+		lines = append(lines, l)
+	}
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for _, l := range lines {
+		if l == "" {
+			p.out = append(p.out, line{})
+		} else {
+			p.out = append(p.out, line{indent: indent + 1, text: l})
+		}
+	}
+
+	end := 0
+	if group, ok := s.Rhs.(*ast.GroupExpr); ok {
+		end = group.RBrace.Line
+	}
+	p.out = append(p.out, line{indent: indent, text: "}", src: end})
+	if end > 0 {
+		p.last = end
+	}
+	p.trailing()
+}
+
+func gaugeText(s *ast.GaugeStmt) string {
+	parts := make([]string, 0, 3)
+	if s.Stitches > 0 {
+		parts = append(parts, fmt.Sprintf("%s sts", strconv.FormatFloat(s.Stitches, 'f', -1, 64)))
+	}
+	if s.Rows > 0 {
+		parts = append(parts, fmt.Sprintf("%s rows", strconv.FormatFloat(s.Rows, 'f', -1, 64)))
+	}
+	if s.Over != nil {
+		parts = append(parts, sizeText(s.Over))
+	}
+	return fmt.Sprintf("gauge(%s)", strings.Join(parts, ", "))
+}
+
+// ------------------ Expressions ------------------
+
+// A name that would lex as one identifier needs no quotes
+func isIdentifier(name string) bool {
+	for i, r := range name {
+		if i == 0 && !unicode.IsLetter(r) {
+			return false
+		}
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' {
+			return false
+		}
+	}
+	return name != ""
+}
+
+func aliasRhs(s *ast.AliasStmt) string {
+	if s.Colour != "" && s.Rhs.Name == s.Colour {
+		return s.Colour
+	}
+	rhs := s.Rhs.Name
+	if !isIdentifier(rhs) {
+		rhs = quote(rhs)
+	}
+	if s.Colour != "" {
+		rhs = fmt.Sprintf("%s %s", rhs, s.Colour)
+	}
+	return rhs
+}
+
+func quote(s string) string {
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// Braces are only needed for a row with arguments, or one starting with a
+// braced row which would otherwise be read as the whole row
+func rowBraced(row *ast.RowExpr) bool {
+	if len(row.Args.Args) > 0 {
+		return true
+	}
+	if len(row.Stitches) > 0 {
+		if _, ok := row.Stitches[0].(*ast.RowExpr); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *printer) rowText(row *ast.RowExpr, braced bool) string {
+	parts := make([]string, 0, len(row.Stitches))
+	for _, stitch := range row.Stitches {
+		parts = append(parts, p.exprText(stitch, false))
+	}
+	text := strings.Join(parts, " ")
+	if braced {
+		return fmt.Sprintf("{ %s }%s", text, p.argsText(row.Args))
+	}
+	return text
+}
+
+func (p *printer) argsText(args ast.Brackets) string {
+	if len(args.Args) == 0 && args.Depth == 0 {
+		return ""
+	}
+	parts := make([]string, len(args.Args))
+	for i, arg := range args.Args {
+		parts[i] = p.exprText(arg, false)
+	}
+	return fmt.Sprintf("(%s)", strings.Join(append(parts, depthText(args.Depth)...), ", "))
+}
+
+// Last in the brackets, if it's given
+func depthText(depth int) []string {
+	if depth == 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("depth=%d", depth)}
+}
+
+func precedence(op ast.ArithOp) int {
+	if op == ast.MUL_OP || op == ast.DIV_OP {
+		return 2
+	}
+	return 1
+}
+
+// `inArith` is for operands, where a variant needs brackets to stay one
+func (p *printer) exprText(expr ast.Expr, inArith bool) string {
+	switch x := expr.(type) {
+	case *ast.StitchExpr:
+		return x.Id.Name + p.argsText(x.Args)
+	case *ast.RowExpr:
+		return p.rowText(x, true)
+	case *ast.StringExpr:
+		return quote(x.Value)
+	case *ast.IdentExpr:
+		return x.Name
+	case *ast.SizeExpr:
+		return sizeText(x)
+	case *ast.VariantExpr:
+		text := variantText(x)
+		if inArith {
+			return fmt.Sprintf("(%s)", text)
+		}
+		return text
+	case *ast.ArithExpr:
+		lhs, rhs := p.exprText(x.X, true), p.exprText(x.Y, true)
+		if y, ok := x.X.(*ast.ArithExpr); ok && precedence(y.Op) < precedence(x.Op) {
+			lhs = fmt.Sprintf("(%s)", lhs)
+		}
+		// Otherwise it could be read as a value for each size
+		if x.Op == ast.DIV_OP && plainNumber(x.X) && plainNumber(x.Y) {
+			lhs = fmt.Sprintf("(%s)", lhs)
+		}
+		// Left to right, so an operation on the right always needs brackets
+		// unless it binds tighter
+		if y, ok := x.Y.(*ast.ArithExpr); ok && precedence(y.Op) <= precedence(x.Op) {
+			rhs = fmt.Sprintf("(%s)", rhs)
+		}
+		return fmt.Sprintf("%s %s %s", lhs, x.Op, rhs)
+	}
+	return ""
+}
+
+// A number as it would be written, `96`
+func plainNumber(x ast.Expr) bool {
+	s, ok := x.(*ast.SizeExpr)
+	return ok && s.Unit == ast.NOUNIT && !s.Before && s.Ni >= 0
+}
+
+func sizeText(s *ast.SizeExpr) string {
+	if s.Unit == ast.ASTERISK {
+		return "*"
+	}
+	n := s.Id.Name
+	if n == "" {
+		if s.Ni >= 0 {
+			n = strconv.FormatInt(s.Ni, 10)
+		} else {
+			n = strconv.FormatFloat(s.Nf, 'f', -1, 64)
+		}
+	}
+	switch s.Unit {
+	case ast.MM:
+		n += "mm"
+	case ast.CM:
+		n += "cm"
+	case ast.INCHES:
+		n += "\""
+	case ast.FEET:
+		n += "'"
+	}
+	if s.Before {
+		n = "-" + n
+	}
+	return n
+}
+
+func variantText(v *ast.VariantExpr) string {
+	values := make([]string, len(v.Values))
+	for i, value := range v.Values {
+		values[i] = sizeText(value)
+	}
+	return strings.Join(values, " | ")
+}
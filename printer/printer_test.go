@@ -0,0 +1,218 @@
+package printer_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bodneyc/knit-and-go/ast"
+	"github.com/bodneyc/knit-and-go/lexer"
+	"github.com/bodneyc/knit-and-go/parser"
+	"github.com/bodneyc/knit-and-go/printer"
+	"github.com/bodneyc/knit-and-go/util"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.ErrorLevel)
+	os.Exit(m.Run())
+}
+
+// Every pattern that parses, patterns meant to error are skipped
+func patterns(t *testing.T) []string {
+	var files []string
+	err := filepath.Walk("../test-patterns", func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && filepath.Ext(path) == ".knit" {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("No patterns found in ../test-patterns")
+	}
+	return files
+}
+
+// Named for the file it stands in for, so its imports are found the same way
+func parse(t *testing.T, file string, text string) (*parser.Parser, bool) {
+	l, err := lexer.NewLexerFromString(file, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := parser.NewParser(*l)
+	err = p.Parse()
+	var diags parser.Diagnostics
+	if errors.As(err, &diags) {
+		return nil, false
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return p, true
+}
+
+func sprint(t *testing.T, p *parser.Parser) string {
+	text, err := printer.Sprint(&p.Root, p.Comments())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return text
+}
+
+// Imports are compiled with the file, each state as `--print-states` shows it
+func states(t *testing.T, p *parser.Parser) []string {
+	e := ast.NewEngineData()
+	p.WalkForLocals(e)
+	if err := p.WalkForLines(e); err != nil {
+		t.Fatal(err)
+	}
+	engine := ast.MakeEngine(e, "")
+	engine.FormStates()
+	engine.CountStitches()
+	states := make([]string, engine.Len())
+	for i := range states {
+		states[i] = engine.StateAt(i).String()
+	}
+	return states
+}
+
+func TestPrintIsIdempotent(t *testing.T) {
+	for _, file := range patterns(t) {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, ok := parse(t, file, string(b))
+		if !ok {
+			continue
+		}
+		once := sprint(t, p)
+		again, ok := parse(t, file, once)
+		if !ok {
+			t.Errorf("%s: printed pattern doesn't parse:\n%s", file, once)
+			continue
+		}
+		if twice := sprint(t, again); twice != once {
+			t.Errorf("%s: printing the printed pattern changes it:\n%s",
+				file, util.UnifiedDiff("once", "twice", once, twice))
+		}
+	}
+}
+
+func TestPrintKeepsStates(t *testing.T) {
+	for _, file := range patterns(t) {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, ok := parse(t, file, string(b))
+		if !ok {
+			continue
+		}
+		printed, ok := parse(t, file, sprint(t, p))
+		if !ok {
+			t.Errorf("%s: printed pattern doesn't parse", file)
+			continue
+		}
+		before, after := states(t, p), states(t, printed)
+		if len(before) != len(after) {
+			t.Errorf("%s: %d states before printing, %d after", file, len(before), len(after))
+			continue
+		}
+		for i := range before {
+			if before[i] != after[i] {
+				t.Errorf("%s: state %d differs once printed:\n%s", file, i,
+					util.UnifiedDiff("before", "after", before[i], after[i]))
+				break
+			}
+		}
+	}
+}
+
+// Something of everything the printer knows, sizes, a stitch definition, a
+// chart, gauge, yarns and an assignment with parameters
+const EVERYTHING = `; A hat
+sizes S M L
+
+MC := navy #1b2a4a
+CC := cream
+gauge(22 sts, 30 rows, 10cm)
+
+stitch k2t {
+  consumes 2 produces 1
+  lean right
+  symbol "/"
+}
+stitch wind { setup }
+
+chart lace {
+  . = k, p
+  o = yo
+  / = k2t
+
+  |. . . .|.
+  |. / o .|.
+}
+
+sts = 88 | 96 | 104
+half = (sts) / 2
+rib(n, y) = { k(n, y) p(n) }(*)
+
+cast-on(sts)
+rib(2, CC)
+{ k(2 | 4 | 6) p(*) }
+lace(2)
+{ k(half) k(*, MC) }
+k((96)/8) k(*)
+`
+
+func TestPrintEverything(t *testing.T) {
+	p, ok := parse(t, "everything.knit", EVERYTHING)
+	if !ok {
+		t.Fatal("Pattern doesn't parse")
+	}
+	once := sprint(t, p)
+	printed, ok := parse(t, "everything.knit", once)
+	if !ok {
+		t.Fatalf("Printed pattern doesn't parse:\n%s", once)
+	}
+	if twice := sprint(t, printed); twice != once {
+		t.Errorf("Printing the printed pattern changes it:\n%s", util.UnifiedDiff("once", "twice", once, twice))
+	}
+	before, after := strings.Join(states(t, p), "\n"), strings.Join(states(t, printed), "\n")
+	if before != after {
+		t.Errorf("States differ once printed:\n%s", util.UnifiedDiff("before", "after", before, after))
+	}
+	for _, want := range []string{"sizes S M L", "k(2 | 4 | 6) p(*)", "k((96) / 8)", "  |. / o .|.", "MC := navy #1b2a4a", "stitch wind { setup }"} {
+		if !strings.Contains(once, want) {
+			t.Errorf("Printed pattern has no %q:\n%s", want, once)
+		}
+	}
+}
+
+// A value for each size is always printed with `|`, a division of plain
+// numbers is bracketed so it can't be read as one
+func TestPrintVariants(t *testing.T) {
+	cases := map[string]string{
+		"co(6 | 3)\n":                   "co(6 | 3)\n",
+		"sizes S M\nco(6 | 3)\n":        "sizes S M\nco(6 | 3)\n",
+		"sizes S M L\nco(6/3)\n":        "sizes S M L\nco((6) / 3)\n",
+		"sizes S M\nk(2cm | 3cm)\n":     "sizes S M\nk(2cm | 3cm)\n",
+		"sizes S M\nk((88 | 96) / 2)\n": "sizes S M\nk((88 | 96) / 2)\n",
+		"sizes S M\nk((96)/2)\n":        "sizes S M\nk((96) / 2)\n",
+	}
+	for text, want := range cases {
+		p, ok := parse(t, "variants.knit", text)
+		if !ok {
+			t.Fatalf("%q doesn't parse", text)
+		}
+		if got := sprint(t, p); got != want {
+			t.Errorf("%q prints as %q, want %q", text, got, want)
+		}
+	}
+}
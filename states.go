@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/bodneyc/knit-and-go/ast"
+	"github.com/bodneyc/knit-and-go/util"
+)
+
+// Where the pattern being run was read from, saved with its states so it can
+// be compiled again from any directory
+func patternSource(args *util.CliArgs) ast.PatternSource {
+	return ast.PatternSource{
+		Infiles:        absPaths(args.Infiles),
+		ImportPaths:    absPaths(args.ImportPaths),
+		Ast:            args.Inform == util.AST_IOF,
+		Size:           args.Size,
+		RecursionDepth: args.RecursionDepth,
+	}
+}
+
+func absPaths(paths []string) []string {
+	abs := make([]string, len(paths))
+	for i, path := range paths {
+		abs[i] = path
+		if path == "-" {
+			continue
+		}
+		if p, err := filepath.Abs(path); err == nil {
+			abs[i] = p
+		}
+	}
+	return abs
+}
+
+// Run the pattern a states file was saved from as it was run then
+func resumeArgs(args *util.CliArgs, source ast.PatternSource) error {
+	if len(source.Infiles) == 0 {
+		return fmt.Errorf("States file doesn't say which pattern it's from")
+	}
+	for _, infile := range source.Infiles {
+		if infile == "-" {
+			return fmt.Errorf("Pattern was read from standard input, it can't be read again")
+		}
+	}
+	args.Infiles = source.Infiles
+	args.ImportPaths = source.ImportPaths
+	args.Size = source.Size
+	args.RecursionDepth = source.RecursionDepth
+	args.Inform = util.KNIT_IOF
+	if source.Ast {
+		args.Inform = util.AST_IOF
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package tui
+
+import (
+	"fmt"
+	"strconv"
+
+	ui "github.com/gizak/termui/v3"
+)
+
+// Levels of each of red, green and blue in the 6x6x6 cube of the 256 colour
+// palette
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// Palette entries from 16 on, those below are left to the terminal's theme
+func paletteRgb(idx int) (int, int, int) {
+	if idx >= 232 {
+		grey := 8 + (idx-232)*10
+		return grey, grey, grey
+	}
+	idx -= 16
+	return cubeLevels[idx/36], cubeLevels[idx/6%6], cubeLevels[idx%6]
+}
+
+// The style name of the nearest terminal colour to a `#rrggbb` hex, added to
+// those termui knows the first time it's seen; empty if the hex isn't valid
+func terminalColour(hex string) string {
+	if len(hex) != 7 || hex[0] != '#' {
+		return ""
+	}
+	rgb, err := strconv.ParseUint(hex[1:], 16, 32)
+	if err != nil {
+		return ""
+	}
+	r, g, b := int(rgb>>16), int(rgb>>8&0xff), int(rgb&0xff)
+
+	best, bestDist := 16, -1
+	for idx := 16; idx < 256; idx++ {
+		pr, pg, pb := paletteRgb(idx)
+		dist := (r-pr)*(r-pr) + (g-pg)*(g-pg) + (b-pb)*(b-pb)
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = idx, dist
+		}
+	}
+
+	name := fmt.Sprintf("colour%d", best)
+	if _, ok := ui.StyleParserColorMap[name]; !ok {
+		ui.StyleParserColorMap[name] = ui.Color(best)
+	}
+	return name
+}
@@ -32,6 +32,7 @@ type Screen struct {
 	secondCtrPar,
 	primaryCtrPar,
 	stateCtrPar,
+	stitchCountPar,
 	prevRow,
 	nextRow,
 	currentRowPar,
@@ -42,19 +43,26 @@ func NewScreen(engine *ast.Engine) *Screen {
 	return &Screen{engine: engine}
 }
 
+// Each fragment in the colour of its yarn, if it has one, the current one is
+// highlighted
 func prettyRowWithHighlight(state *ast.CurrentState) string {
 	var s []string
-	for idx, fragment := range state.Lc.Row {
-		if fragment == "{" || (len(state.Lc.Row) > idx+1 && state.Lc.Row[idx+1][0] == '}') {
-			if idx == state.Ctr.StitchPhrase {
-				fragment = fmt.Sprintf("[%s](fg:magenta)", fragment)
-			}
+	for idx, p := range state.Lc.Phrases {
+		fragment := p.Text
+		braced := state.Lc.Braced(idx)
+		colour := terminalColour(p.Colour)
+		switch {
+		case idx == state.Ctr.StitchPhrase && colour != "":
+			fragment = fmt.Sprintf("[%s](fg:%s,mod:reverse)", fragment, colour)
+		case idx == state.Ctr.StitchPhrase:
+			fragment = fmt.Sprintf("[%s](fg:magenta)", fragment)
+		case colour != "":
+			fragment = fmt.Sprintf("[%s](fg:%s)", fragment, colour)
+		}
+		if braced {
 			s = append(s, fragment)
 		} else {
-			if idx == state.Ctr.StitchPhrase {
-				fragment = fmt.Sprintf("[%s](fg:magenta)", fragment)
-			}
-			if idx == len(state.Lc.Row)-1 {
+			if idx == len(state.Lc.Phrases)-1 {
 				s = append(s, fmt.Sprintf("%s", fragment))
 			} else {
 				s = append(s, fmt.Sprintf("%s,", fragment))
@@ -78,6 +86,7 @@ A: primary down
 s: secondary up
 S: secondary down
 x: ctr reset
+c: repeat done
 	^s: save](fg:blue)`
 
 	s.blockDescPar = w.NewParagraph()
@@ -123,6 +132,10 @@ x: ctr reset
 	s.stateCtrPar.Title = "Page counter"
 	s.stateCtrPar.TitleStyle.Modifier = ui.ModifierBold
 
+	s.stitchCountPar = w.NewParagraph()
+	s.stitchCountPar.Title = "Stitches on needle"
+	s.stitchCountPar.TitleStyle.Modifier = ui.ModifierBold
+
 	s.currentRowPar = w.NewParagraph()
 	s.currentRowPar.Title = "Current row"
 	s.currentRowPar.TitleStyle.Modifier = ui.ModifierBold
@@ -142,28 +155,59 @@ x: ctr reset
 	s.prevRow.TitleStyle.Modifier = ui.ModifierBold
 }
 
+// Maxes worked out from a measurement are only as good as the gauge
+func approxMark(approx bool) string {
+	if approx {
+		return "~"
+	}
+	return ""
+}
+
+// An open-ended repeat has no max, only the condition and perhaps an estimate
+func untilText(ctr, max int, approx bool, until string) string {
+	until = ast.UntilPhrase(until)
+	if max != 0 {
+		until = fmt.Sprintf("%s%d, %s", approxMark(approx), max, until)
+	}
+	return fmt.Sprintf("[%d](fg:yellow)/[%s](fg:green)\n[c when done](fg:blue)", ctr, until)
+}
+
 func (s *Screen) setParagraphs(state *ast.CurrentState) error {
 	s.blockDescPar.Text = fmt.Sprintf("[%s](fg:green)", state.Desc.Block)
+	// The pass of each repeat the row is in, `sleeve > lace 3/8 > row 2/4`
+	s.blockDescPar.Title = "Descriptions"
+	if crumbs := state.Breadcrumb(); crumbs != "" {
+		s.blockDescPar.Title = fmt.Sprintf("Descriptions, %s", crumbs)
+	}
 	s.groupDescPar.Text = fmt.Sprintf("[%s](fg:green)", state.Desc.Group)
-	if state.GroupMax != 0 {
+	if state.GroupUntil != "" {
+		s.groupCtrPar.Text = untilText(state.GroupCtr, state.GroupMax, state.GroupApprox, state.GroupUntil)
+	} else if state.GroupMax != 0 {
 		lcol := "yellow"
 		if state.GroupCtr == state.GroupMax {
 			lcol = "green"
 		}
-		s.groupCtrPar.Text = fmt.Sprintf("[%d](fg:%s)/[%d](fg:green)", state.GroupCtr, lcol, state.GroupMax)
+		s.groupCtrPar.Text = fmt.Sprintf("[%d](fg:%s)/[%s%d](fg:green)",
+			state.GroupCtr, lcol, approxMark(state.GroupApprox), state.GroupMax)
 	} else {
 		s.groupCtrPar.Text = ""
 	}
 	s.rowDescPar.Text = fmt.Sprintf("[%s](fg:green)", state.Desc.Row)
-	if state.RowMax != 0 {
+	if state.RowUntil != "" {
+		s.rowCtrPar.Text = untilText(state.RowCtr, state.RowMax, state.RowApprox, state.RowUntil)
+	} else if state.RowMax != 0 {
 		lcol := "yellow"
 		if state.RowCtr == state.RowMax {
 			lcol = "green"
 		}
-		s.rowCtrPar.Text = fmt.Sprintf("[%d](fg:%s)/[%d](fg:green)", state.RowCtr, lcol, state.RowMax)
+		s.rowCtrPar.Text = fmt.Sprintf("[%d](fg:%s)/[%s%d](fg:green)",
+			state.RowCtr, lcol, approxMark(state.RowApprox), state.RowMax)
 	} else {
 		s.rowCtrPar.Text = ""
 	}
+	if state.Side != ast.NO_SIDE {
+		s.rowCtrPar.Text = strings.TrimPrefix(fmt.Sprintf("%s\n[%s](fg:cyan)", s.rowCtrPar.Text, state.SideText()), "\n")
+	}
 	if state.Ctr.Stitch == 0 {
 		s.primaryCtrPar.Text = fmt.Sprintf("[%s](fg:red)", strconv.Itoa(state.Ctr.Stitch))
 	} else {
@@ -175,20 +219,44 @@ func (s *Screen) setParagraphs(state *ast.CurrentState) error {
 		s.secondCtrPar.Text = fmt.Sprintf("[%s](fg:green)", strconv.Itoa(state.Ctr.Row))
 	}
 	lcol := "yellow"
-	if s.engine.StateIdx == len(s.engine.States)-1 {
+	if s.engine.StateIdx == s.engine.Len()-1 {
 		lcol = "green"
 	}
-	s.stateCtrPar.Text = fmt.Sprintf("[%d](fg:%s)/[%d](fg:green)", s.engine.StateIdx, lcol, len(s.engine.States)-1)
+	s.stateCtrPar.Text = fmt.Sprintf("[%d](fg:%s)/[%d](fg:green)", s.engine.StateIdx, lcol, s.engine.Len()-1)
+	// Pages include setup instructions, rows are numbered as the pattern's are
+	if state.Setup {
+		s.stateCtrPar.Text += "\n[setup](fg:cyan)"
+	} else {
+		s.stateCtrPar.Text += fmt.Sprintf("\n[row %d](fg:cyan)", state.Row)
+	}
+	if state.Stitches < 0 {
+		s.stitchCountPar.Text = fmt.Sprintf("[%s](fg:yellow)", state.StitchesText())
+	} else {
+		s.stitchCountPar.Text = fmt.Sprintf("[%s](fg:green)", state.StitchesText())
+	}
 	s.currentRowPar.Text = prettyRowWithHighlight(state)
+	// The markers met working the row are those left by the one before
+	s.currentRowPar.Title = fmt.Sprintf("Current %s", state.RowText())
+	if state.Setup {
+		s.currentRowPar.Title = "Setup"
+	}
+	if s.engine.StateIdx-1 >= 0 {
+		if prev := s.engine.StateAt(s.engine.StateIdx - 1); len(prev.Markers) > 0 {
+			s.currentRowPar.Title = fmt.Sprintf("%s, markers: %s", s.currentRowPar.Title, s.engine.MarkersMetText(s.engine.StateIdx))
+		}
+	}
 	s.argsPar.Text = strings.Join(state.Lc.Args, ", ")
 
 	if s.engine.StateIdx-1 >= 0 {
-		s.prevRow.Text = fmt.Sprintf("[%s](fg:blue)", s.engine.States[s.engine.StateIdx-1].HistRow)
+		s.prevRow.Text = fmt.Sprintf("[%s](fg:blue)", s.engine.StateAt(s.engine.StateIdx-1).HistRow)
 	} else {
 		s.prevRow.Text = "[Start of pattern](fg:blue)"
 	}
-	if s.engine.StateIdx+1 < len(s.engine.States) {
-		s.nextRow.Text = fmt.Sprintf("[%s](fg:cyan)", s.engine.States[s.engine.StateIdx+1].HistRow)
+	if state.LoopLen > 0 && !state.Done {
+		start := s.engine.StateIdx - state.LoopLen + 1
+		s.nextRow.Text = fmt.Sprintf("[%s](fg:cyan)", s.engine.StateAt(start).HistRow)
+	} else if s.engine.StateIdx+1 < s.engine.Len() {
+		s.nextRow.Text = fmt.Sprintf("[%s](fg:cyan)", s.engine.StateAt(s.engine.StateIdx+1).HistRow)
 	} else {
 		s.nextRow.Text = "[End of pattern](fg:cyan)"
 	}
@@ -204,7 +272,7 @@ func (s *Screen) Run() (*util.LogrusCalls, error) {
 
 	s.paragraphSetup()
 
-	state := &s.engine.States[s.engine.StateIdx]
+	state := s.engine.State()
 	s.setParagraphs(state)
 
 	width, height := ui.TerminalDimensions()
@@ -241,9 +309,10 @@ func (s *Screen) Run() (*util.LogrusCalls, error) {
 		),
 		ui.NewRow(0.1,
 			// Counters
-			ui.NewCol(1.0/3, s.primaryCtrPar),
-			ui.NewCol(1.0/3, s.secondCtrPar),
-			ui.NewCol(1.0/3, s.stateCtrPar),
+			ui.NewCol(1.0/4, s.primaryCtrPar),
+			ui.NewCol(1.0/4, s.secondCtrPar),
+			ui.NewCol(1.0/4, s.stitchCountPar),
+			ui.NewCol(1.0/4, s.stateCtrPar),
 		),
 	)
 
@@ -272,6 +341,21 @@ func (s *Screen) Run() (*util.LogrusCalls, error) {
 				"Moved to prev state",
 			))
 
+		case "c", "<Enter>":
+			var done bool
+			state, done = s.engine.ConfirmState()
+			if done {
+				logCalls.Trace = append(logCalls.Trace, util.MakeLogrusCall(
+					log.WithField("state", s.engine.StateIdx),
+					"Open-ended repeat done",
+				))
+			} else {
+				logCalls.Trace = append(logCalls.Trace, util.MakeLogrusCall(
+					log.WithField("state", s.engine.StateIdx),
+					"Not at the end of an open-ended repeat",
+				))
+			}
+
 		case "<C-s>":
 			s.engine.WriteEngine()
 			logCalls.Info = append(logCalls.Info, util.MakeLogrusCall(
@@ -280,19 +364,18 @@ func (s *Screen) Run() (*util.LogrusCalls, error) {
 			))
 
 		case "l", "<Right>":
-			if len(state.Lc.Row) > state.Ctr.StitchPhrase+1 {
+			if len(state.Lc.Phrases) > state.Ctr.StitchPhrase+1 {
 				state.Ctr.StitchPhrase += 1
-				phrase := state.Lc.Row[s.engine.States[s.engine.StateIdx].Ctr.StitchPhrase]
-				if (phrase == "{" || strings.HasPrefix(phrase, "}")) && state.Ctr.StitchPhrase-1 >= 0 {
+				if state.Lc.IsBrace(state.Ctr.StitchPhrase) && state.Ctr.StitchPhrase-1 >= 0 {
 					state.Ctr.StitchPhrase += 1
 				}
 				logCalls.Trace = append(logCalls.Trace, util.MakeLogrusCall(
-					log.WithField("stitch", s.engine.States[s.engine.StateIdx].Ctr.StitchPhrase),
+					log.WithField("stitch", state.Ctr.StitchPhrase),
 					"Moved to right stitch",
 				))
 			} else {
 				logCalls.Trace = append(logCalls.Trace, util.MakeLogrusCall(
-					log.WithField("stitch", s.engine.States[s.engine.StateIdx].Ctr.StitchPhrase),
+					log.WithField("stitch", state.Ctr.StitchPhrase),
 					"Already at rightmost stitch",
 				))
 			}
@@ -300,17 +383,16 @@ func (s *Screen) Run() (*util.LogrusCalls, error) {
 		case "h", "<Left>":
 			if state.Ctr.StitchPhrase-1 >= 0 {
 				state.Ctr.StitchPhrase -= 1
-				phrase := state.Lc.Row[s.engine.States[s.engine.StateIdx].Ctr.StitchPhrase]
-				if (phrase == "{" || strings.HasPrefix(phrase, "}")) && state.Ctr.StitchPhrase-1 >= 0 {
+				if state.Lc.IsBrace(state.Ctr.StitchPhrase) && state.Ctr.StitchPhrase-1 >= 0 {
 					state.Ctr.StitchPhrase -= 1
 				}
 				logCalls.Trace = append(logCalls.Trace, util.MakeLogrusCall(
-					log.WithField("stitch", s.engine.States[s.engine.StateIdx].Ctr.StitchPhrase),
+					log.WithField("stitch", state.Ctr.StitchPhrase),
 					"Moved to left stitch",
 				))
 			} else {
 				logCalls.Trace = append(logCalls.Trace, util.MakeLogrusCall(
-					log.WithField("stitch", s.engine.States[s.engine.StateIdx].Ctr.StitchPhrase),
+					log.WithField("stitch", state.Ctr.StitchPhrase),
 					"Already at leftmost stitch",
 				))
 			}
@@ -318,7 +400,7 @@ func (s *Screen) Run() (*util.LogrusCalls, error) {
 		case "a":
 			state.Ctr.Stitch += 1
 			logCalls.Trace = append(logCalls.Trace, util.MakeLogrusCall(
-				log.WithField("stitch", s.engine.States[s.engine.StateIdx].Ctr.Stitch),
+				log.WithField("stitch", state.Ctr.Stitch),
 				"Increased stitch",
 			))
 
@@ -326,12 +408,12 @@ func (s *Screen) Run() (*util.LogrusCalls, error) {
 			if state.Ctr.Stitch-1 >= 0 {
 				state.Ctr.Stitch -= 1
 				logCalls.Trace = append(logCalls.Trace, util.MakeLogrusCall(
-					log.WithField("stitch", s.engine.States[s.engine.StateIdx].Ctr.Stitch),
+					log.WithField("stitch", state.Ctr.Stitch),
 					"Decreased stitch counter",
 				))
 			} else {
 				logCalls.Trace = append(logCalls.Trace, util.MakeLogrusCall(
-					log.WithField("stitch", s.engine.States[s.engine.StateIdx].Ctr.Stitch),
+					log.WithField("stitch", state.Ctr.Stitch),
 					"Cannot decrease stitch counter further",
 				))
 			}
@@ -339,7 +421,7 @@ func (s *Screen) Run() (*util.LogrusCalls, error) {
 		case "s":
 			state.Ctr.Row += 1
 			logCalls.Trace = append(logCalls.Trace, util.MakeLogrusCall(
-				log.WithField("row", s.engine.States[s.engine.StateIdx].Ctr.Row),
+				log.WithField("row", state.Ctr.Row),
 				"Increased row counter",
 			))
 
@@ -347,12 +429,12 @@ func (s *Screen) Run() (*util.LogrusCalls, error) {
 			if state.Ctr.Row-1 >= 0 {
 				state.Ctr.Row -= 1
 				logCalls.Trace = append(logCalls.Trace, util.MakeLogrusCall(
-					log.WithField("row", s.engine.States[s.engine.StateIdx].Ctr.Row),
+					log.WithField("row", state.Ctr.Row),
 					"Decreased row counter",
 				))
 			} else {
 				logCalls.Trace = append(logCalls.Trace, util.MakeLogrusCall(
-					log.WithField("row", s.engine.States[s.engine.StateIdx].Ctr.Row),
+					log.WithField("row", state.Ctr.Row),
 					"Cannot decrease row counter further",
 				))
 			}
@@ -362,8 +444,8 @@ func (s *Screen) Run() (*util.LogrusCalls, error) {
 			state.Ctr.Row = 0
 			logCalls.Trace = append(logCalls.Trace, util.MakeLogrusCall(
 				log.WithFields(log.Fields{
-					"row":    s.engine.States[s.engine.StateIdx].Ctr.Row,
-					"stitch": s.engine.States[s.engine.StateIdx].Ctr.Stitch,
+					"row":    state.Ctr.Row,
+					"stitch": state.Ctr.Stitch,
 				}),
 				"Reset counters",
 			))
@@ -17,6 +17,36 @@ const (
 	STATES_IOF
 )
 
+// What the program was asked to do, run a pattern or a subcommand
+type Command int
+
+const (
+	RUN_CMD Command = iota
+	FMT_CMD
+	LINT_CMD
+)
+
+type CheckLevel int
+
+const (
+	ILLEGAL_CL CheckLevel = iota
+	OFF_CL
+	WARN_CL
+	ERROR_CL
+)
+
+func toCheckLevel(s string) (CheckLevel, error) {
+	if strings.EqualFold(s, "off") {
+		return OFF_CL, nil
+	} else if strings.EqualFold(s, "warn") {
+		return WARN_CL, nil
+	} else if strings.EqualFold(s, "error") {
+		return ERROR_CL, nil
+	} else {
+		return ILLEGAL_CL, fmt.Errorf("Unknown check level: %s", s)
+	}
+}
+
 func toIOform(s string) (IOform, error) {
 	if strings.EqualFold(s, "knit") {
 		return KNIT_IOF, nil
@@ -25,13 +55,15 @@ func toIOform(s string) (IOform, error) {
 	} else if strings.EqualFold(s, "states") {
 		return STATES_IOF, nil
 	} else {
-		return ILLEGAL_IOF, fmt.Errorf("Unknown IOform: %s%s", s, StackLine())
+		return ILLEGAL_IOF, fmt.Errorf("Unknown IOform: %s", s)
 	}
 }
 
 type CliArgs struct {
+	Command         Command
 	Inform          IOform
 	Infiles         []string
+	ImportPaths     []string
 	AstFile         string
 	StatesFile      string
 	NoRun           bool
@@ -39,14 +71,28 @@ type CliArgs struct {
 	LogTimer        bool
 	PrintEngineData bool
 	PrintStates     bool
+	StitchCheck     CheckLevel
+	MaxFloat        int
+	RecursionDepth  int
+	Size            string
+	// For `fmt`
+	Write bool
+	Diff  bool
+	// For `lint`, severities by rule name
+	Rules map[string]CheckLevel
+	Json  bool
 }
 
 func ParseCli() (*CliArgs, error) {
 	args := &CliArgs{}
 	var informStr string
+	var stitchCheckStr string
+	importPaths := cli.NewStringSlice()
+	rules := cli.NewStringSlice()
 	app := &cli.App{
-		Name:  "Knit and Go",
-		Usage: "Run a knitting pattern in a TUI",
+		Name:      "Knit and Go",
+		Usage:     "Run a knitting pattern in a TUI",
+		ArgsUsage: "[input files, - for standard input]",
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:        "inform",
@@ -55,6 +101,13 @@ func ParseCli() (*CliArgs, error) {
 				Usage:       "Input file format",
 				Destination: &informStr,
 			},
+			&cli.StringSliceFlag{
+				Name:        "import-path",
+				Aliases:     []string{"I"},
+				Usage:       "Directories searched for imports not relative to the importing file",
+				EnvVars:     []string{"KNIT_PATH"},
+				Destination: importPaths,
+			},
 			&cli.StringFlag{
 				Name:        "ast",
 				Value:       "",
@@ -67,6 +120,31 @@ func ParseCli() (*CliArgs, error) {
 				Usage:       "Write knit program states to this file as JSON",
 				Destination: &args.StatesFile,
 			},
+			&cli.StringFlag{
+				Name:        "stitch-check",
+				Value:       "warn",
+				Usage:       "What to do when stitch counts don't add up (off, warn, error)",
+				Destination: &stitchCheckStr,
+			},
+			&cli.IntFlag{
+				Name:        "max-float",
+				Value:       5,
+				Usage:       "Longest a yarn can be carried behind the work in stranded rows, in stitches (0 for no check)",
+				Destination: &args.MaxFloat,
+			},
+			&cli.IntFlag{
+				Name:        "recursion-depth",
+				Value:       0,
+				Usage:       "How deep an assignment may call itself where neither it nor the call gives a depth, calls any deeper are left out (0 for none)",
+				Destination: &args.RecursionDepth,
+			},
+			&cli.StringFlag{
+				Name:        "size",
+				Aliases:     []string{"s"},
+				Value:       "",
+				Usage:       "Size to knit, one of those named in the pattern's sizes (default: the first)",
+				Destination: &args.Size,
+			},
 			&cli.BoolFlag{
 				Name:        "no-run",
 				Aliases:     []string{"norun"},
@@ -100,25 +178,125 @@ func ParseCli() (*CliArgs, error) {
 				Destination: &args.LogTimer,
 			},
 		},
+		Commands: []*cli.Command{
+			{
+				Name:      "fmt",
+				Usage:     "Print .knit files in a canonical layout",
+				ArgsUsage: "[input files, standard input if none]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:        "w",
+						Usage:       "Write the result back to each file rather than printing it",
+						Destination: &args.Write,
+					},
+					&cli.BoolFlag{
+						Name:        "d",
+						Usage:       "Print a diff of each file against the result",
+						Destination: &args.Diff,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					args.Command = FMT_CMD
+					args.Inform = KNIT_IOF
+					args.ImportPaths = importPaths.Value()
+					args.Infiles = c.Args().Slice()
+					if len(args.Infiles) == 0 {
+						args.Infiles = []string{"-"}
+					}
+					for _, infile := range args.Infiles {
+						if infile == "-" && args.Write {
+							return fmt.Errorf("Standard input can't be written back to")
+						}
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "lint",
+				Usage:     "Report likely mistakes in a pattern",
+				ArgsUsage: "[input files, standard input if none]",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:        "rule",
+						Aliases:     []string{"r"},
+						Usage:       "Severity of a rule, as rule=level (off, warn, error)",
+						Destination: rules,
+					},
+					&cli.BoolFlag{
+						Name:        "json",
+						Usage:       "Print findings as JSON",
+						Destination: &args.Json,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					args.Command = LINT_CMD
+					args.Inform = KNIT_IOF
+					args.ImportPaths = importPaths.Value()
+					args.Infiles = c.Args().Slice()
+					if len(args.Infiles) == 0 {
+						args.Infiles = []string{"-"}
+					}
+					args.Rules = make(map[string]CheckLevel)
+					for _, rule := range rules.Value() {
+						kv := strings.SplitN(rule, "=", 2)
+						if len(kv) != 2 {
+							return fmt.Errorf("Rule %s should be given as rule=level", rule)
+						}
+						level, err := toCheckLevel(kv[1])
+						if err != nil {
+							return err
+						}
+						args.Rules[kv[0]] = level
+					}
+					return nil
+				},
+			},
+		},
 		Action: func(c *cli.Context) error {
 			if c.NArg() < 1 {
-				return fmt.Errorf("No input files given%s", StackLine())
+				return fmt.Errorf("No input files given")
 			}
 
 			args.Infiles = c.Args().Slice()
+			args.ImportPaths = importPaths.Value()
 
 			var err error
 			if args.Inform, err = toIOform(informStr); err != nil {
-				return fmt.Errorf("%w%s", err, StackLine())
+				return err
+			}
+
+			if args.StitchCheck, err = toCheckLevel(stitchCheckStr); err != nil {
+				return err
+			}
+
+			if args.MaxFloat < 0 {
+				return fmt.Errorf("Max float can't be negative")
+			}
+
+			if args.RecursionDepth < 0 {
+				return fmt.Errorf("Recursion depth can't be negative")
 			}
 
 			if args.Inform == AST_IOF && c.NArg() != 1 {
-				return fmt.Errorf("Only one input file for inform ast%s", StackLine())
+				return fmt.Errorf("Only one input file for inform ast")
+			}
+
+			stdin := 0
+			for _, infile := range args.Infiles {
+				if infile == "-" {
+					stdin++
+				}
+			}
+			if stdin > 1 {
+				return fmt.Errorf("Standard input (-) given more than once")
 			}
 
 			if args.Inform == STATES_IOF {
 				if c.NArg() != 1 {
-					return fmt.Errorf("Only one input file for inform states%s", StackLine())
+					return fmt.Errorf("Only one input file for inform states")
+				}
+				if stdin > 0 {
+					return fmt.Errorf("Inform states is written back to, so can't be read from standard input")
 				}
 				if args.StatesFile == "" {
 					args.StatesFile = args.Infiles[0]